@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"sync"
 )
 
 // AccessPointStatus represents the status passed to get the access point count for a given status
@@ -17,8 +18,20 @@ const (
 	Missing  AccessPointStatus = "missing"
 )
 
+// Valid reports whether the status is one of All, Active, Inactive or Missing.
+func (s AccessPointStatus) Valid() bool {
+	switch s {
+	case All, Active, Inactive, Missing:
+		return true
+	}
+	return false
+}
+
 // AccessPointsResponse provides a list of missing access points returned by ListAccessPoints
-type AccessPointsResponse []struct {
+type AccessPointsResponse []AccessPoint
+
+// AccessPoint represents a single access point in the AccessPointsResponse
+type AccessPoint struct {
 	// ap mac
 	ApMac string `json:"apMac,omitempty"`
 
@@ -41,6 +54,41 @@ type AccessPointsCountResponse struct {
 	Count int64 `json:"count"`
 }
 
+// AccessPointSummary provides the count of access points for every status from GetCounts()
+type AccessPointSummary struct {
+	All      int64 `json:"all"`
+	Active   int64 `json:"active"`
+	Inactive int64 `json:"inactive"`
+	Missing  int64 `json:"missing"`
+}
+
+// ActivePercent returns the percentage of all access points that are active, or 0 if there are none.
+func (s AccessPointSummary) ActivePercent() float64 { return s.percent(s.Active) }
+
+// InactivePercent returns the percentage of all access points that are inactive, or 0 if there are none.
+func (s AccessPointSummary) InactivePercent() float64 { return s.percent(s.Inactive) }
+
+// MissingPercent returns the percentage of all access points that are missing, or 0 if there are none.
+func (s AccessPointSummary) MissingPercent() float64 { return s.percent(s.Missing) }
+
+func (s AccessPointSummary) percent(n int64) float64 {
+	if s.All == 0 {
+		return 0
+	}
+	return 100 * float64(n) / float64(s.All)
+}
+
+// Discrepancy returns All less the sum of the active, inactive and missing counts.  The counts are fetched
+// separately, so access points changing status in between, or having a status not covered, can make it non zero.
+func (s AccessPointSummary) Discrepancy() int64 {
+	return s.All - (s.Active + s.Inactive + s.Missing)
+}
+
+// Consistent reports whether the active, inactive and missing counts add up to All.
+func (s AccessPointSummary) Consistent() bool {
+	return s.Discrepancy() == 0
+}
+
 // ListAccessPoints retrieves a list of missing access points.
 // The only valid status is "missing" and is therefore not provided as an option.
 func (s *AccessPointsService) ListAccessPoints(ctx context.Context) (AccessPointsResponse, error) {
@@ -57,13 +105,16 @@ func (s *AccessPointsService) ListAccessPoints(ctx context.Context) (AccessPoint
 }
 
 // GetCount retrieves the count of the active, inactive, missing or all the access points.
-// If no parameters are given, the count of all active access points is returned.
-// Status may be missing, active, inactive or all
+// If no status is given, the count of all active access points is returned.
+// Status may be missing, active, inactive or all, and any other status returns ErrInvalidAccessPointStatus.
 func (s *AccessPointsService) GetCount(ctx context.Context, status AccessPointStatus) (AccessPointsCountResponse, error) {
 	if status == "" {
-		status = "missing"
+		status = Active
 	}
 	apcr := AccessPointsCountResponse{}
+	if !status.Valid() {
+		return apcr, fmt.Errorf("%w: %q", ErrInvalidAccessPointStatus, status)
+	}
 	url := fmt.Sprintf("%s/accessPoints/count?status=%s", s.client.BaseURL, status)
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
@@ -74,3 +125,37 @@ func (s *AccessPointsService) GetCount(ctx context.Context, status AccessPointSt
 	}
 	return apcr, nil
 }
+
+// GetCounts retrieves the count of all, active, inactive and missing access points concurrently.
+// If any count can't be retrieved the remaining requests are cancelled and the first error is returned.
+func (s *AccessPointsService) GetCounts(ctx context.Context) (AccessPointSummary, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	statuses := []AccessPointStatus{All, Active, Inactive, Missing}
+	counts := make([]int64, len(statuses))
+	var mu sync.Mutex
+	var firstErr error
+	var wg sync.WaitGroup
+	for i, status := range statuses {
+		wg.Add(1)
+		go func(i int, status AccessPointStatus) {
+			defer wg.Done()
+			apcr, err := s.GetCount(ctx, status)
+			if err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				mu.Unlock()
+				return
+			}
+			counts[i] = apcr.Count
+		}(i, status)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return AccessPointSummary{}, firstErr
+	}
+	return AccessPointSummary{All: counts[0], Active: counts[1], Inactive: counts[2], Missing: counts[3]}, nil
+}
@@ -0,0 +1,100 @@
+package dnas
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newCountServer returns a client for a server that responds to access point counts with handler.
+func newCountServer(t *testing.T, handler func(w http.ResponseWriter, r *http.Request, status string)) *Client {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/accessPoints/count" {
+			t.Errorf("path = %s, want /accessPoints/count", r.URL.Path)
+		}
+		handler(w, r, r.URL.Query().Get("status"))
+	}))
+	t.Cleanup(srv.Close)
+	c, err := NewClient("test-key", "io", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.BaseURL = srv.URL
+	return c
+}
+
+func TestAccessPointGetCount(t *testing.T) {
+	var mu sync.Mutex
+	var statuses []string
+	c := newCountServer(t, func(w http.ResponseWriter, r *http.Request, status string) {
+		mu.Lock()
+		statuses = append(statuses, status)
+		mu.Unlock()
+		fmt.Fprint(w, `{"count":7}`)
+	})
+	ctx := context.Background()
+
+	res, err := c.AccessPointsService.GetCount(ctx, "")
+	if err != nil || res.Count != 7 {
+		t.Errorf("GetCount = %v, %v, want 7", res, err)
+	}
+	if _, err := c.AccessPointsService.GetCount(ctx, Missing); err != nil {
+		t.Error(err)
+	}
+	if _, err := c.AccessPointsService.GetCount(ctx, "down"); !errors.Is(err, ErrInvalidAccessPointStatus) {
+		t.Errorf("GetCount(down) err = %v, want ErrInvalidAccessPointStatus", err)
+	}
+	// The empty status is the active count, and invalid statuses aren't sent.
+	if len(statuses) != 2 || statuses[0] != "active" || statuses[1] != "missing" {
+		t.Errorf("requested statuses %q, want [active missing]", statuses)
+	}
+}
+
+func TestAccessPointGetCounts(t *testing.T) {
+	counts := map[string]int{"all": 10, "active": 6, "inactive": 1, "missing": 2}
+	c := newCountServer(t, func(w http.ResponseWriter, r *http.Request, status string) {
+		fmt.Fprintf(w, `{"count":%d}`, counts[status])
+	})
+	s, err := c.AccessPointsService.GetCounts(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s != (AccessPointSummary{All: 10, Active: 6, Inactive: 1, Missing: 2}) {
+		t.Errorf("summary = %+v", s)
+	}
+	if s.Consistent() || s.Discrepancy() != 1 || s.ActivePercent() != 60 {
+		t.Errorf("discrepancy %d and %v%% active, want 1 and 60%%", s.Discrepancy(), s.ActivePercent())
+	}
+	if (AccessPointSummary{}).MissingPercent() != 0 {
+		t.Error("percentage of no access points should be 0")
+	}
+}
+
+func TestAccessPointGetCountsCancel(t *testing.T) {
+	c := newCountServer(t, func(w http.ResponseWriter, r *http.Request, status string) {
+		if status == "inactive" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		// The other requests only finish when cancelled.
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+			fmt.Fprint(w, `{"count":1}`)
+		}
+	})
+	start := time.Now()
+	_, err := c.AccessPointsService.GetCounts(context.Background())
+	if !errors.Is(err, ErrInternalError) {
+		t.Errorf("GetCounts err = %v, want ErrInternalError", err)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Error("GetCounts waited for the remaining requests instead of cancelling them")
+	}
+}
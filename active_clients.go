@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 // ClientParameters represent the options for ListClients and GetCount
@@ -199,6 +201,23 @@ type LocationDevice struct {
 	Username string `json:"userName,omitempty"`
 }
 
+// LocatedAt returns the time the device was last located.
+// LastLocationAt is used where it can be parsed, falling back to ChangedOn.  The zero time is returned if neither is available.
+func (d LocationDevice) LocatedAt() time.Time {
+	if d.LastLocationAt != "" {
+		if t, err := time.Parse(time.RFC3339, d.LastLocationAt); err == nil {
+			return t
+		}
+		if ms, err := strconv.ParseInt(d.LastLocationAt, 10, 64); err == nil {
+			return millisToTime(ms)
+		}
+	}
+	if d.ChangedOn != 0 {
+		return millisToTime(d.ChangedOn)
+	}
+	return time.Time{}
+}
+
 // ClientCountResponse provides the count for the active devices from GetCount()
 type ClientCountResponse struct {
 	Results struct {
@@ -240,6 +259,26 @@ func (s *ActiveClientsService) ListClients(ctx context.Context, opts *ClientPara
 	return ldr, nil
 }
 
+// ListAllClients returns the active clients from every page of ListClients.  The Page parameter is ignored.
+func (s *ActiveClientsService) ListAllClients(ctx context.Context, opts *ClientParameters) ([]LocationDevice, error) {
+	params := ClientParameters{}
+	if opts != nil {
+		params = *opts
+	}
+	var devices []LocationDevice
+	for page := 1; ; page++ {
+		params.Page = String(strconv.Itoa(page))
+		ldr, err := s.ListClients(ctx, &params)
+		if err != nil {
+			return devices, err
+		}
+		devices = append(devices, ldr.Results...)
+		if !ldr.MorePage || len(ldr.Results) == 0 {
+			return devices, nil
+		}
+	}
+}
+
 // GetCount retrieves the active clients count. The API supports searching by a variety of parameters.
 // If no parameters are given, the count of all active clients are returned.
 func (s *ActiveClientsService) GetCount(ctx context.Context, opts *ClientParameters) (ClientCountResponse, error) {
@@ -0,0 +1,266 @@
+package dnas
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HealthFlag represents an anomaly found by an APHealthAnalyzer
+type HealthFlag string
+
+// Fields for HealthFlag
+const (
+	// HealthSilent is an access point that was sending messages and has stopped.
+	HealthSilent HealthFlag = "SILENT"
+	// HealthRateCollapse is a message rate that has fallen well below its 15 minute rate or its own baseline.
+	HealthRateCollapse HealthFlag = "RATE_COLLAPSE"
+	// HealthRateSpike is a message rate that has risen well above its 15 minute rate or its own baseline.
+	HealthRateSpike HealthFlag = "RATE_SPIKE"
+	// HealthBelowFleet is a message rate well below the median of all access points.
+	HealthBelowFleet HealthFlag = "BELOW_FLEET"
+	// HealthAboveFleet is a message rate well above the median of all access points.
+	HealthAboveFleet HealthFlag = "ABOVE_FLEET"
+)
+
+// healthPenalty is the amount each flag reduces an access point's score by.
+var healthPenalty = map[HealthFlag]float64{
+	HealthSilent:       60,
+	HealthRateCollapse: 40,
+	HealthBelowFleet:   25,
+	HealthRateSpike:    20,
+	HealthAboveFleet:   10,
+}
+
+// APHealth is the health of a single access point in an APHealthReport.
+type APHealth struct {
+	ApMac   string  `json:"apMac"`
+	Count   int64   `json:"count"`
+	M1Rate  float64 `json:"m1Rate"`
+	M5Rate  float64 `json:"m5Rate"`
+	M15Rate float64 `json:"m15Rate"`
+
+	// Trend is the ratio of the 1 minute rate to the 15 minute rate, or 1 if there is no 15 minute rate.
+	Trend float64 `json:"trend"`
+
+	// Baseline is the median 1 minute rate of the access point in previous snapshots, or 0 if there are too few.
+	Baseline float64 `json:"baseline"`
+
+	// Score is from 0 to 100, with 100 being healthy.
+	Score float64      `json:"score"`
+	Flags []HealthFlag `json:"flags,omitempty"`
+}
+
+// APHealthReport is the health of the access points in a snapshot, ranked least healthy first.
+type APHealthReport struct {
+	Time time.Time `json:"time"`
+
+	// FleetMedian is the median 1 minute rate of the access points in the snapshot.
+	FleetMedian float64 `json:"fleetMedian"`
+
+	AccessPoints []APHealth `json:"accessPoints"`
+}
+
+// APHealthAnalyzer scores access points using the message rates returned by ListAccessPoints.  Each access point is
+// compared with its own 15 minute rate, with its baseline from previous snapshots, and with the rest of the fleet.
+// Ratios of zero use the defaults given for each field.
+//
+// The baselines of access points absent from a snapshot are dropped, so an access point that reappears starts a new
+// baseline and the analyzer only keeps history for the access points in the latest snapshot.
+type APHealthAnalyzer struct {
+	// CollapseRatio flags a rate below this fraction of the 15 minute rate or baseline.  Default 0.5.
+	CollapseRatio float64
+
+	// SpikeRatio flags a rate above this multiple of the 15 minute rate or baseline.  Default 2.
+	SpikeRatio float64
+
+	// FleetLowRatio and FleetHighRatio flag a rate below or above these multiples of the fleet median.
+	// Defaults 0.25 and 4.
+	FleetLowRatio  float64
+	FleetHighRatio float64
+
+	// BaselineSamples is the number of previous snapshots used for each access point's baseline.  Default 60.
+	BaselineSamples int
+
+	// MinBaselineSamples is the number of previous snapshots needed before the baseline is used.  Default 3.
+	MinBaselineSamples int
+
+	mu      sync.Mutex
+	history map[string][]float64
+}
+
+// NewAPHealthAnalyzer returns an APHealthAnalyzer using the default thresholds.
+func NewAPHealthAnalyzer() *APHealthAnalyzer {
+	return &APHealthAnalyzer{}
+}
+
+// AnalyzeAPHealth adds each snapshot in turn to a new APHealthAnalyzer and returns the report for the last one.
+func AnalyzeAPHealth(snapshots []AccessPointsResponse) APHealthReport {
+	a := NewAPHealthAnalyzer()
+	var report APHealthReport
+	for _, s := range snapshots {
+		report = a.Add(s, time.Time{})
+	}
+	return report
+}
+
+// Add scores the access points in a snapshot taken at the given time, then adds the snapshot to the baselines and
+// drops the baselines of access points not in it.
+func (a *APHealthAnalyzer) Add(snapshot AccessPointsResponse, at time.Time) APHealthReport {
+	collapse := defaultFloat(a.CollapseRatio, 0.5)
+	spike := defaultFloat(a.SpikeRatio, 2)
+	fleetLow := defaultFloat(a.FleetLowRatio, 0.25)
+	fleetHigh := defaultFloat(a.FleetHighRatio, 4)
+	samples := a.BaselineSamples
+	if samples <= 0 {
+		samples = 60
+	}
+	minSamples := a.MinBaselineSamples
+	if minSamples <= 0 {
+		minSamples = 3
+	}
+
+	rates := make([]float64, 0, len(snapshot))
+	for _, ap := range snapshot {
+		rates = append(rates, ap.M1Rate)
+	}
+	report := APHealthReport{Time: at, FleetMedian: medianFloat64(rates)}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.history == nil {
+		a.history = make(map[string][]float64)
+	}
+	seen := make(map[string]bool, len(snapshot))
+	for _, ap := range snapshot {
+		mac := strings.ToLower(ap.ApMac)
+		seen[mac] = true
+		h := APHealth{ApMac: mac, Count: ap.Count, M1Rate: ap.M1Rate, M5Rate: ap.M5Rate, M15Rate: ap.M15Rate, Trend: 1}
+		if ap.M15Rate > 0 {
+			h.Trend = ap.M1Rate / ap.M15Rate
+		}
+		past := a.history[mac]
+		if len(past) >= minSamples {
+			h.Baseline = medianFloat64(past)
+		}
+
+		flags := make(map[HealthFlag]bool)
+		if ap.M1Rate == 0 && (ap.M15Rate > 0 || h.Baseline > 0) {
+			flags[HealthSilent] = true
+		} else {
+			if ap.M15Rate > 0 && h.Trend < collapse || h.Baseline > 0 && ap.M1Rate < h.Baseline*collapse {
+				flags[HealthRateCollapse] = true
+			}
+			if report.FleetMedian > 0 && ap.M1Rate < report.FleetMedian*fleetLow {
+				flags[HealthBelowFleet] = true
+			}
+		}
+		if ap.M15Rate > 0 && h.Trend > spike || h.Baseline > 0 && ap.M1Rate > h.Baseline*spike {
+			flags[HealthRateSpike] = true
+		}
+		if report.FleetMedian > 0 && ap.M1Rate > report.FleetMedian*fleetHigh {
+			flags[HealthAboveFleet] = true
+		}
+
+		h.Score = 100
+		for _, f := range []HealthFlag{HealthSilent, HealthRateCollapse, HealthRateSpike, HealthBelowFleet, HealthAboveFleet} {
+			if flags[f] {
+				h.Flags = append(h.Flags, f)
+				h.Score -= healthPenalty[f]
+			}
+		}
+		h.Score = math.Max(0, h.Score)
+		report.AccessPoints = append(report.AccessPoints, h)
+
+		past = append(past, ap.M1Rate)
+		if len(past) > samples {
+			past = past[len(past)-samples:]
+		}
+		a.history[mac] = past
+	}
+	for mac := range a.history {
+		if !seen[mac] {
+			delete(a.history, mac)
+		}
+	}
+
+	sort.SliceStable(report.AccessPoints, func(i, j int) bool {
+		if report.AccessPoints[i].Score != report.AccessPoints[j].Score {
+			return report.AccessPoints[i].Score < report.AccessPoints[j].Score
+		}
+		return report.AccessPoints[i].ApMac < report.AccessPoints[j].ApMac
+	})
+	return report
+}
+
+// Unhealthy returns the access points with at least one flag, least healthy first.
+func (r APHealthReport) Unhealthy() []APHealth {
+	var unhealthy []APHealth
+	for _, h := range r.AccessPoints {
+		if len(h.Flags) > 0 {
+			unhealthy = append(unhealthy, h)
+		}
+	}
+	return unhealthy
+}
+
+// WriteCSV writes the report as CSV with one row per access point.
+func (r APHealthReport) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"apMac", "score", "flags", "m1Rate", "m15Rate", "trend", "baseline", "fleetMedian"}); err != nil {
+		return err
+	}
+	for _, h := range r.AccessPoints {
+		flags := make([]string, len(h.Flags))
+		for i, f := range h.Flags {
+			flags[i] = string(f)
+		}
+		if err := cw.Write([]string{
+			h.ApMac,
+			strconv.FormatFloat(h.Score, 'f', 0, 64),
+			strings.Join(flags, " "),
+			strconv.FormatFloat(h.M1Rate, 'f', 3, 64),
+			strconv.FormatFloat(h.M15Rate, 'f', 3, 64),
+			strconv.FormatFloat(h.Trend, 'f', 3, 64),
+			strconv.FormatFloat(h.Baseline, 'f', 3, 64),
+			strconv.FormatFloat(r.FleetMedian, 'f', 3, 64),
+		}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteJSON writes the report as JSON.
+func (r APHealthReport) WriteJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(r)
+}
+
+// defaultFloat returns v, or def if v is not positive.
+func defaultFloat(v, def float64) float64 {
+	if v <= 0 {
+		return def
+	}
+	return v
+}
+
+// medianFloat64 returns the median of the values without modifying them, or 0 if there are none.
+func medianFloat64(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
@@ -0,0 +1,107 @@
+package dnas
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// healthOf returns the health of the access point in the report, failing if it isn't there.
+func healthOf(t *testing.T, r APHealthReport, mac string) APHealth {
+	t.Helper()
+	for _, h := range r.AccessPoints {
+		if h.ApMac == mac {
+			return h
+		}
+	}
+	t.Fatalf("%s not in report", mac)
+	return APHealth{}
+}
+
+func TestAPHealthFlags(t *testing.T) {
+	report := NewAPHealthAnalyzer().Add(AccessPointsResponse{
+		{ApMac: "ok", M1Rate: 10},
+		{ApMac: "ok2", M1Rate: 10, M15Rate: 10},
+		{ApMac: "silent", M1Rate: 0, M15Rate: 5},
+		{ApMac: "collapse", M1Rate: 4, M15Rate: 10},
+		{ApMac: "spike", M1Rate: 25, M15Rate: 10},
+		{ApMac: "LOW", M1Rate: 1},
+		{ApMac: "high", M1Rate: 50},
+	}, time.Time{})
+
+	if report.FleetMedian != 10 {
+		t.Errorf("fleet median = %v, want 10", report.FleetMedian)
+	}
+	tests := []struct {
+		mac   string
+		score float64
+		flags string
+	}{
+		{"ok", 100, "[]"},
+		{"ok2", 100, "[]"},
+		{"silent", 40, "[SILENT]"},
+		{"collapse", 60, "[RATE_COLLAPSE]"},
+		{"spike", 80, "[RATE_SPIKE]"},
+		{"low", 75, "[BELOW_FLEET]"},
+		{"high", 90, "[ABOVE_FLEET]"},
+	}
+	for _, tt := range tests {
+		h := healthOf(t, report, tt.mac)
+		if h.Score != tt.score || fmt.Sprint(h.Flags) != tt.flags {
+			t.Errorf("%s scored %v with %v, want %v with %s", tt.mac, h.Score, h.Flags, tt.score, tt.flags)
+		}
+	}
+
+	var order []string
+	for _, h := range report.AccessPoints {
+		order = append(order, h.ApMac)
+	}
+	if fmt.Sprint(order) != "[silent collapse low spike high ok ok2]" {
+		t.Errorf("ranked %v, want least healthy first", order)
+	}
+	if n := len(report.Unhealthy()); n != 5 {
+		t.Errorf("%d unhealthy, want 5", n)
+	}
+}
+
+func TestAPHealthBaseline(t *testing.T) {
+	a := NewAPHealthAnalyzer()
+	steady := AccessPoint{ApMac: "b", M1Rate: 10}
+	for i := 0; i < 3; i++ {
+		a.Add(AccessPointsResponse{{ApMac: "a", M1Rate: 10}, steady}, time.Time{})
+	}
+	report := a.Add(AccessPointsResponse{{ApMac: "a", M1Rate: 2}, steady}, time.Time{})
+	if h := healthOf(t, report, "a"); h.Baseline != 10 || fmt.Sprint(h.Flags) != "[RATE_COLLAPSE]" {
+		t.Errorf("baseline %v with %v, want 10 and a collapse", h.Baseline, h.Flags)
+	}
+
+	// An access point absent from a snapshot loses its baseline.
+	a.Add(AccessPointsResponse{steady}, time.Time{})
+	if len(a.history) != 1 {
+		t.Errorf("history kept for %d access points, want 1", len(a.history))
+	}
+	report = a.Add(AccessPointsResponse{{ApMac: "a", M1Rate: 8}, steady}, time.Time{})
+	if h := healthOf(t, report, "a"); h.Baseline != 0 || len(h.Flags) != 0 {
+		t.Errorf("baseline %v with %v after reappearing, want a new baseline", h.Baseline, h.Flags)
+	}
+	if h := healthOf(t, report, "b"); h.Baseline != 10 {
+		t.Errorf("baseline of b = %v, want 10", h.Baseline)
+	}
+}
+
+func TestAPHealthWriteCSV(t *testing.T) {
+	report := AnalyzeAPHealth([]AccessPointsResponse{
+		{{ApMac: "a", M1Rate: 10, M15Rate: 10}},
+		{{ApMac: "a", M1Rate: 0, M15Rate: 10}},
+	})
+	var buf bytes.Buffer
+	if err := report.WriteCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := "apMac,score,flags,m1Rate,m15Rate,trend,baseline,fleetMedian\n" +
+		"a,40,SILENT,0.000,10.000,0.000,0.000,0.000\n"
+	if buf.String() != want {
+		t.Errorf("csv\n%s\nwant\n%s", buf.String(), want)
+	}
+}
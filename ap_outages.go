@@ -0,0 +1,410 @@
+package dnas
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultOutageInterval is the time between polls of an OutageTracker when none is given.
+const DefaultOutageInterval = time.Minute
+
+// DefaultOutageCounts is the number of count samples kept by an OutageTracker when none is given, which is a week
+// of samples at the DefaultOutageInterval.
+const DefaultOutageCounts = 7 * 24 * 60
+
+// Outage is a period during which an access point was reported missing.
+type Outage struct {
+	ApMac string    `json:"apMac"`
+	Start time.Time `json:"start"`
+
+	// End is the time the access point was first seen to have recovered, or the zero time if it is still missing.
+	End time.Time `json:"end"`
+}
+
+// Ongoing reports whether the access point is still missing.
+func (o Outage) Ongoing() bool {
+	return o.End.IsZero()
+}
+
+// Duration returns the length of the outage, using now as the end if it is ongoing.
+func (o Outage) Duration(now time.Time) time.Duration {
+	if o.Ongoing() {
+		return now.Sub(o.Start)
+	}
+	return o.End.Sub(o.Start)
+}
+
+// AccessPointCounts is a sample of the access point counts taken by an OutageTracker.
+type AccessPointCounts struct {
+	Time    time.Time `json:"time"`
+	All     int64     `json:"all"`
+	Missing int64     `json:"missing"`
+}
+
+// OutageTracker polls the missing access points and keeps the outage intervals of each access point,
+// creating an APEvent of type EventAPDown when an access point goes missing and EventAPUp when it recovers.
+// Outages are timed to the poll that observed them, so they are accurate to within the polling interval.
+//
+// If more than twice the Interval passes between polls, for example while the program was stopped, the time in
+// between isn't counted as observed, so it isn't counted as downtime either.  The outages of every access point
+// that has been missing are kept until Prune is called, so a long running tracker should call it periodically.
+type OutageTracker struct {
+	// Interval is the time between polls.  If zero, DefaultOutageInterval is used.
+	Interval time.Duration
+
+	// MaxCounts is the number of count samples kept by Poll, dropping the oldest first.  If zero, DefaultOutageCounts is used.
+	MaxCounts int
+
+	// ErrorLog is used to log polling and handler errors in Run.  If nil, they are not logged.
+	ErrorLog *log.Logger
+
+	s *AccessPointsService
+
+	mu    sync.Mutex
+	state outageState
+}
+
+// outageState is the state of an OutageTracker, as saved by Save.
+type outageState struct {
+	// Observed are the periods covered by polling, in order.  A new period is started when the time since the
+	// previous poll is more than twice the interval.
+	Observed []observedPeriod    `json:"observed"`
+	Outages  map[string][]Outage `json:"outages"`
+	Counts   []AccessPointCounts `json:"counts,omitempty"`
+}
+
+// observedPeriod is a period during which the tracker polled without a gap.
+type observedPeriod struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// last returns the time of the most recent poll.
+func (s *outageState) last() time.Time {
+	if len(s.Observed) == 0 {
+		return time.Time{}
+	}
+	return s.Observed[len(s.Observed)-1].End
+}
+
+// NewOutageTracker returns an OutageTracker polling the access points at the given interval.
+func NewOutageTracker(s *AccessPointsService, interval time.Duration) *OutageTracker {
+	return &OutageTracker{s: s, Interval: interval}
+}
+
+// Run polls until the context is done, calling fn for each event.  Errors from polling and from fn are logged,
+// and do not stop the OutageTracker.
+func (t *OutageTracker) Run(ctx context.Context, fn EventHandler) error {
+	interval := t.Interval
+	if interval <= 0 {
+		interval = DefaultOutageInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		events, err := t.Poll(ctx)
+		if err != nil && ctx.Err() == nil {
+			t.logf("dnas: outage tracker: %v", err)
+		}
+		for _, ev := range events {
+			if err := fn(ctx, ev); err != nil {
+				t.logf("dnas: outage tracker: handling event %s: %v", ev.Header().ID, err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll retrieves the missing access points and the access point counts, and returns the events since the previous poll.
+func (t *OutageTracker) Poll(ctx context.Context) ([]Event, error) {
+	missing, err := t.s.ListAccessPoints(ctx)
+	if err != nil {
+		return nil, err
+	}
+	counts, err := t.s.GetCounts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	t.mu.Lock()
+	t.state.Counts = append(t.state.Counts, AccessPointCounts{Time: now, All: counts.All, Missing: counts.Missing})
+	limit := t.MaxCounts
+	if limit <= 0 {
+		limit = DefaultOutageCounts
+	}
+	if n := len(t.state.Counts) - limit; n > 0 {
+		t.state.Counts = append([]AccessPointCounts(nil), t.state.Counts[n:]...)
+	}
+	t.mu.Unlock()
+	return t.Observe(missing, now), nil
+}
+
+// Observe records the access points missing at the given time and returns the resulting events.
+// It can be used instead of Poll to supply the results of ListAccessPoints yourself.
+func (t *OutageTracker) Observe(missing AccessPointsResponse, at time.Time) []Event {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.state.Outages == nil {
+		t.state.Outages = make(map[string][]Outage)
+	}
+	interval := t.Interval
+	if interval <= 0 {
+		interval = DefaultOutageInterval
+	}
+	if n := len(t.state.Observed); n > 0 && at.Sub(t.state.Observed[n-1].End) <= 2*interval {
+		if at.After(t.state.Observed[n-1].End) {
+			t.state.Observed[n-1].End = at
+		}
+	} else {
+		t.state.Observed = append(t.state.Observed, observedPeriod{Start: at, End: at})
+	}
+
+	var events []Event
+	emit := func(typ EventType, mac string) {
+		ts := timeToMillis(at)
+		status := string(Missing)
+		if typ == EventAPUp {
+			status = string(Active)
+		}
+		events = append(events, &APEvent{
+			EventHeader: EventHeader{ID: fmt.Sprintf("%s-%s-%d", typ, mac, ts), Type: typ, Timestamp: ts},
+			AccessPoint: AccessPointState{ApMac: mac, Status: status},
+		})
+	}
+
+	down := make(map[string]bool, len(missing))
+	for _, ap := range missing {
+		mac := strings.ToLower(ap.ApMac)
+		if mac == "" || down[mac] {
+			continue
+		}
+		down[mac] = true
+		outages := t.state.Outages[mac]
+		if len(outages) == 0 || !outages[len(outages)-1].Ongoing() {
+			t.state.Outages[mac] = append(outages, Outage{ApMac: mac, Start: at})
+			emit(EventAPDown, mac)
+		}
+	}
+
+	macs := make([]string, 0, len(t.state.Outages))
+	for mac := range t.state.Outages {
+		macs = append(macs, mac)
+	}
+	sort.Strings(macs)
+	for _, mac := range macs {
+		outages := t.state.Outages[mac]
+		if last := &outages[len(outages)-1]; last.Ongoing() && !down[mac] {
+			last.End = at
+			emit(EventAPUp, mac)
+		}
+	}
+	return events
+}
+
+// Outages returns the outages of every access point that has been missing, ordered by access point and start time.
+func (t *OutageTracker) Outages() []Outage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var all []Outage
+	for _, outages := range t.state.Outages {
+		all = append(all, outages...)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].ApMac != all[j].ApMac {
+			return all[i].ApMac < all[j].ApMac
+		}
+		return all[i].Start.Before(all[j].Start)
+	})
+	return all
+}
+
+// Counts returns the access point counts recorded by Poll.
+func (t *OutageTracker) Counts() []AccessPointCounts {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]AccessPointCounts(nil), t.state.Counts...)
+}
+
+// Prune removes outages, count samples and observed periods that ended before the given time.
+func (t *OutageTracker) Prune(before time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for mac, outages := range t.state.Outages {
+		kept := outages[:0]
+		for _, o := range outages {
+			if o.Ongoing() || !o.End.Before(before) {
+				kept = append(kept, o)
+			}
+		}
+		if len(kept) == 0 {
+			delete(t.state.Outages, mac)
+		} else {
+			t.state.Outages[mac] = kept
+		}
+	}
+	i := sort.Search(len(t.state.Counts), func(i int) bool { return !t.state.Counts[i].Time.Before(before) })
+	t.state.Counts = append([]AccessPointCounts(nil), t.state.Counts[i:]...)
+	i = sort.Search(len(t.state.Observed), func(i int) bool { return !t.state.Observed[i].End.Before(before) })
+	t.state.Observed = append([]observedPeriod(nil), t.state.Observed[i:]...)
+}
+
+// Save writes the state of the tracker as JSON so that it can be restored with Load after a restart.
+func (t *OutageTracker) Save(w io.Writer) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(t.state)
+}
+
+// Load restores the state written by Save, replacing the current state.
+func (t *OutageTracker) Load(r io.Reader) error {
+	var state outageState
+	if err := json.NewDecoder(r).Decode(&state); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.state = state
+	return nil
+}
+
+// APAvailability is the availability of a single access point on a single day.
+type APAvailability struct {
+	ApMac string `json:"apMac"`
+
+	// Date is the day in the form 2006-01-02.
+	Date string `json:"date"`
+
+	// Observed is the part of the day covered by polling, and Downtime is how much of that the access point was missing.
+	Observed time.Duration `json:"observed"`
+	Downtime time.Duration `json:"downtime"`
+
+	// Availability is the percentage of the observed time the access point was not missing.
+	Availability float64 `json:"availability"`
+
+	// Outages is the number of outages that overlap the day.
+	Outages int `json:"outages"`
+}
+
+// AvailabilityReport is the daily availability of each access point, ordered by date and access point.
+type AvailabilityReport []APAvailability
+
+// DailyReport returns the availability of each access point that has been missing, for each day between from and to.
+// Days are calculated in the given location, or UTC if loc is nil, and only the time covered by polling is counted,
+// leaving out gaps of more than twice the Interval between polls.  Access points that have never been missing are
+// not known to the tracker, so are not included and should be taken as fully available.
+func (t *OutageTracker) DailyReport(from, to time.Time, loc *time.Location) AvailabilityReport {
+	if loc == nil {
+		loc = time.UTC
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var report AvailabilityReport
+	if len(t.state.Observed) == 0 {
+		return report
+	}
+	macs := make([]string, 0, len(t.state.Outages))
+	for mac := range t.state.Outages {
+		macs = append(macs, mac)
+	}
+	sort.Strings(macs)
+	last := t.state.last()
+
+	from = from.In(loc)
+	for day := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, loc); day.Before(to); day = day.AddDate(0, 0, 1) {
+		// periods are the observed parts of the day.
+		var periods []observedPeriod
+		var observed time.Duration
+		for _, p := range t.state.Observed {
+			if s, e, ok := overlap(p.Start, p.End, day, day.AddDate(0, 0, 1)); ok {
+				periods = append(periods, observedPeriod{Start: s, End: e})
+				observed += e.Sub(s)
+			}
+		}
+		if observed == 0 {
+			continue
+		}
+		for _, mac := range macs {
+			a := APAvailability{ApMac: mac, Date: day.Format("2006-01-02"), Observed: observed}
+			for _, o := range t.state.Outages[mac] {
+				oEnd := o.End
+				if o.Ongoing() {
+					oEnd = last
+				}
+				counted := false
+				for _, p := range periods {
+					if s, e, ok := overlap(o.Start, oEnd, p.Start, p.End); ok {
+						a.Downtime += e.Sub(s)
+						counted = true
+					}
+				}
+				if counted {
+					a.Outages++
+				}
+			}
+			a.Availability = 100 * (1 - float64(a.Downtime)/float64(observed))
+			report = append(report, a)
+		}
+	}
+	return report
+}
+
+// overlap returns the intersection of two periods, and reports whether it is not empty.
+func overlap(s1, e1, s2, e2 time.Time) (time.Time, time.Time, bool) {
+	if s2.After(s1) {
+		s1 = s2
+	}
+	if e2.Before(e1) {
+		e1 = e2
+	}
+	return s1, e1, e1.After(s1)
+}
+
+// WriteCSV writes the report as CSV with one row per access point per day.
+func (r AvailabilityReport) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"date", "apMac", "observedSeconds", "downtimeSeconds", "availability", "outages"}); err != nil {
+		return err
+	}
+	for _, a := range r {
+		if err := cw.Write([]string{
+			a.Date,
+			a.ApMac,
+			strconv.FormatFloat(a.Observed.Seconds(), 'f', 0, 64),
+			strconv.FormatFloat(a.Downtime.Seconds(), 'f', 0, 64),
+			strconv.FormatFloat(a.Availability, 'f', 3, 64),
+			strconv.Itoa(a.Outages),
+		}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteJSON writes the report as JSON.
+func (r AvailabilityReport) WriteJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(r)
+}
+
+// logf logs to ErrorLog, if set.
+func (t *OutageTracker) logf(format string, args ...interface{}) {
+	if t.ErrorLog != nil {
+		t.ErrorLog.Printf(format, args...)
+	}
+}
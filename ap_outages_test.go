@@ -0,0 +1,174 @@
+package dnas
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var day0 = time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+
+func missingAPs(macs ...string) AccessPointsResponse {
+	var r AccessPointsResponse
+	for _, mac := range macs {
+		r = append(r, AccessPoint{ApMac: mac})
+	}
+	return r
+}
+
+// eventNames returns the type and access point of each event.
+func eventNames(events []Event) []string {
+	var names []string
+	for _, ev := range events {
+		names = append(names, fmt.Sprintf("%s:%s", ev.Header().Type, ev.(*APEvent).AccessPoint.ApMac))
+	}
+	return names
+}
+
+func TestOutageTrackerObserve(t *testing.T) {
+	tr := NewOutageTracker(nil, time.Minute)
+	steps := []struct {
+		missing AccessPointsResponse
+		want    string
+	}{
+		{missingAPs("A", "b"), "AP_DOWN:a AP_DOWN:b"},
+		{missingAPs("a", "b", "b"), ""},
+		{missingAPs("b"), "AP_UP:a"},
+		{missingAPs("a"), "AP_DOWN:a AP_UP:b"},
+		{nil, "AP_UP:a"},
+	}
+	for i, step := range steps {
+		got := strings.Join(eventNames(tr.Observe(step.missing, day0.Add(time.Duration(i)*time.Minute))), " ")
+		if got != step.want {
+			t.Errorf("step %d: events %q, want %q", i, got, step.want)
+		}
+	}
+	outages := tr.Outages()
+	want := []Outage{
+		{ApMac: "a", Start: day0, End: day0.Add(2 * time.Minute)},
+		{ApMac: "a", Start: day0.Add(3 * time.Minute), End: day0.Add(4 * time.Minute)},
+		{ApMac: "b", Start: day0, End: day0.Add(3 * time.Minute)},
+	}
+	if fmt.Sprint(outages) != fmt.Sprint(want) {
+		t.Errorf("outages %v, want %v", outages, want)
+	}
+}
+
+func TestOutageTrackerDailyReport(t *testing.T) {
+	tr := NewOutageTracker(nil, time.Hour)
+	// Polled hourly from 00:00 to 12:00 on the first day, with "a" missing from 06:00 to 08:00.
+	for h := 0; h <= 12; h++ {
+		var missing AccessPointsResponse
+		if h >= 6 && h < 8 {
+			missing = missingAPs("a")
+		}
+		tr.Observe(missing, day0.Add(time.Duration(h)*time.Hour))
+	}
+	// After a gap, polled from 20:00 on the second day with "a" missing until midnight, when polling stops.
+	for h := 44; h <= 48; h++ {
+		tr.Observe(missingAPs("a"), day0.Add(time.Duration(h)*time.Hour))
+	}
+
+	report := tr.DailyReport(day0, day0.AddDate(0, 0, 3), nil)
+	want := AvailabilityReport{
+		{ApMac: "a", Date: "2021-03-01", Observed: 12 * time.Hour, Downtime: 2 * time.Hour, Availability: 100 * (1 - float64(2*time.Hour)/float64(12*time.Hour)), Outages: 1},
+		{ApMac: "a", Date: "2021-03-02", Observed: 4 * time.Hour, Downtime: 4 * time.Hour, Availability: 0, Outages: 1},
+	}
+	if fmt.Sprint(report) != fmt.Sprint(want) {
+		t.Errorf("report\n%v\nwant\n%v", report, want)
+	}
+
+	var buf bytes.Buffer
+	if err := report.WriteCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	wantCSV := "date,apMac,observedSeconds,downtimeSeconds,availability,outages\n" +
+		"2021-03-01,a,43200,7200,83.333,1\n" +
+		"2021-03-02,a,14400,14400,0.000,1\n"
+	if buf.String() != wantCSV {
+		t.Errorf("csv\n%s\nwant\n%s", buf.String(), wantCSV)
+	}
+
+	if report := NewOutageTracker(nil, 0).DailyReport(day0, day0.AddDate(0, 0, 1), nil); len(report) != 0 {
+		t.Errorf("report %v without polling, want none", report)
+	}
+}
+
+func TestOutageTrackerSaveLoadPrune(t *testing.T) {
+	tr := NewOutageTracker(nil, time.Minute)
+	tr.Observe(missingAPs("a"), day0)
+	tr.Observe(nil, day0.Add(time.Minute))
+	tr.Observe(missingAPs("b"), day0.Add(time.Hour))
+
+	var buf bytes.Buffer
+	if err := tr.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	restored := NewOutageTracker(nil, time.Minute)
+	if err := restored.Load(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(restored.Outages()) != fmt.Sprint(tr.Outages()) {
+		t.Errorf("restored outages %v, want %v", restored.Outages(), tr.Outages())
+	}
+	// The ongoing outage continues from the saved state.
+	if got := eventNames(restored.Observe(nil, day0.Add(time.Hour+time.Minute))); len(got) != 1 || got[0] != "AP_UP:b" {
+		t.Errorf("events %q after loading, want b up", got)
+	}
+
+	restored.Prune(day0.Add(30 * time.Minute))
+	if outages := restored.Outages(); len(outages) != 1 || outages[0].ApMac != "b" {
+		t.Errorf("outages %v after pruning, want only b", outages)
+	}
+}
+
+func TestOutageTrackerPoll(t *testing.T) {
+	var mu sync.Mutex
+	missing := `[{"apMac":"a"}]`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/accessPoints":
+			fmt.Fprint(w, missing)
+		case "/accessPoints/count":
+			counts := map[string]int{"all": 10, "active": 8, "inactive": 1, "missing": 1}
+			fmt.Fprintf(w, `{"count":%d}`, counts[r.URL.Query().Get("status")])
+		default:
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+	}))
+	defer srv.Close()
+	c, err := NewClient("test-key", "io", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.BaseURL = srv.URL
+
+	tr := NewOutageTracker(c.AccessPointsService, time.Minute)
+	tr.MaxCounts = 2
+	for i, want := range []string{"AP_DOWN:a", "", "AP_UP:a"} {
+		if i == 2 {
+			mu.Lock()
+			missing = `[]`
+			mu.Unlock()
+		}
+		events, err := tr.Poll(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(eventNames(events), " "); got != want {
+			t.Errorf("poll %d: events %q, want %q", i, got, want)
+		}
+	}
+	counts := tr.Counts()
+	if len(counts) != 2 || counts[1].All != 10 || counts[1].Missing != 1 {
+		t.Errorf("counts %v, want the last 2 samples", counts)
+	}
+}
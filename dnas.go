@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"reflect"
@@ -98,22 +99,61 @@ func Int(v int) *int { return &v }
 // to store v and returns a pointer to it.
 func Int64(v int64) *int64 { return &v }
 
+// Float64 is a helper routine that allocates a new float64 value
+// to store v and returns a pointer to it.
+func Float64(v float64) *float64 { return &v }
+
 // String is a helper routine that allocates a new string value
 // to store v and returns a pointer to it.
 func String(v string) *string { return &v }
 
 // makeRequest provides a single function to add common items to the request.
 func (c *Client) makeRequest(ctx context.Context, req *http.Request, v interface{}) error {
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.APIKey))
 	req.Header.Set("Accept", "application/json")
-	req.WithContext(ctx)
-	res, err := c.HTTPClient.Do(req)
+	res, err := c.do(ctx, req)
 	if err != nil {
 		return err
 	}
 	defer res.Body.Close()
 
+	if v == nil || res.StatusCode == http.StatusNoContent {
+		return nil
+	}
+
+	if res.Header.Get("Content-Type") == "text/csv" {
+		reader := csv.NewReader(res.Body)
+		records, err := reader.ReadAll()
+		if err != nil {
+			return err
+		}
+		if p, ok := v.(*[][]string); ok {
+			*p = records
+		} else {
+			return errors.New("invalid type assertion: v interface{} should be *[][]string for csv records")
+		}
+		return nil
+	}
+
+	// Some endpoints, such as POST and PUT, may respond without a body.
+	if err = json.NewDecoder(res.Body).Decode(&v); err != nil && err != io.EOF {
+		return err
+	}
+
+	return nil
+}
+
+// do authenticates and sends the request, converting error responses to one of the error constants.
+// The caller is responsible for closing the response body.
+func (c *Client) do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.APIKey))
+	req = req.WithContext(ctx)
+	res, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
 	if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusBadRequest {
+		defer res.Body.Close()
 
 		var ciscoErr error
 
@@ -135,33 +175,11 @@ func (c *Client) makeRequest(ctx context.Context, req *http.Request, v interface
 			ciscoErr = fmt.Errorf("%w: %s", ciscoErr, errRes.Message)
 		}
 
-		return ciscoErr
-
-	}
-
-	if res.StatusCode == http.StatusCreated {
-		return nil
-	}
-
-	if res.Header.Get("Content-Type") == "text/csv" {
-		reader := csv.NewReader(res.Body)
-		records, err := reader.ReadAll()
-		if err != nil {
-			return err
-		}
-		if p, ok := v.(*[][]string); ok {
-			*p = records
-		} else {
-			return errors.New("invalid type assertion: v interface{} should be *[][]string for csv records")
-		}
-		return nil
-	}
+		return nil, ciscoErr
 
-	if err = json.NewDecoder(res.Body).Decode(&v); err != nil {
-		return err
 	}
 
-	return nil
+	return res, nil
 }
 
 // addOptions adds the parameters in opts as URL query parameters to s. opts
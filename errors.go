@@ -22,3 +22,19 @@ const (
 	ErrInternalError = Err("dnas: internal error")
 	ErrUnknown       = Err("dnas: unexpected error occurred")
 )
+
+// Library Error Constants
+// These are returned by the library itself rather than by DNA Spaces.
+const (
+	ErrChecksumMismatch = Err("dnas: checksum mismatch")
+	ErrBusClosed        = Err("dnas: event bus closed")
+	ErrBufferFull       = Err("dnas: subscriber buffer full")
+	ErrQueueClosed      = Err("dnas: event queue closed")
+	ErrCorruptEvent     = Err("dnas: corrupt event in queue")
+	ErrSequencerClosed  = Err("dnas: event sequencer closed")
+
+	// ErrSkipChildren can be returned from a WalkFunc to skip the children of the current node.
+	ErrSkipChildren = Err("dnas: skip children")
+
+	ErrInvalidAccessPointStatus = Err("dnas: invalid access point status")
+)
@@ -0,0 +1,375 @@
+package dnas
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"runtime/debug"
+	"strings"
+	"sync"
+)
+
+// OverflowPolicy represents what an EventBus does when a subscriber's buffer is full
+type OverflowPolicy int
+
+// Fields for OverflowPolicy
+const (
+	// OverflowDrop discards the event for that subscriber and counts it in Dropped.
+	OverflowDrop OverflowPolicy = iota
+	// OverflowBlock waits for space in the buffer, or for the context given to Publish to be done.
+	OverflowBlock
+	// OverflowError discards the event and returns ErrBufferFull from Publish.
+	OverflowError
+)
+
+// DefaultBusBuffer is the number of events buffered for each subscriber when none is given.
+const DefaultBusBuffer = 100
+
+// EventFilter limits the events delivered to a subscriber.  An empty field matches every event, and otherwise
+// the event must match one of the values of each field that is set.  Values are compared case insensitively.
+// Events without a device, such as APEvent, only match DeviceTypes and SSIDs if those are empty.
+type EventFilter struct {
+	FloorIDs    []string
+	DeviceTypes []string
+	SSIDs       []string
+}
+
+// Match reports whether the event passes the filter.
+func (f EventFilter) Match(ev Event) bool {
+	switch e := ev.(type) {
+	case *DeviceEvent:
+		return matchAny(f.FloorIDs, e.Device.FloorID) && matchAny(f.DeviceTypes, e.Device.DeviceType) && matchAny(f.SSIDs, e.Device.SSID)
+	case *APEvent:
+		return matchAny(f.FloorIDs, e.AccessPoint.FloorID) && len(f.DeviceTypes) == 0 && len(f.SSIDs) == 0
+	}
+	return len(f.FloorIDs) == 0 && len(f.DeviceTypes) == 0 && len(f.SSIDs) == 0
+}
+
+// matchAny reports whether values is empty or contains v.
+func matchAny(values []string, v string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, s := range values {
+		if strings.EqualFold(s, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// SubscribeOptions represent the options for a subscriber to an EventBus
+type SubscribeOptions struct {
+	Filter EventFilter
+
+	// Buffer is the number of events queued for each worker.  If zero, DefaultBusBuffer is used.
+	Buffer int
+
+	// Overflow selects what happens when the buffer is full.
+	Overflow OverflowPolicy
+
+	// Workers is the number of goroutines calling the handler.  Events for the same device mac address are always
+	// handled by the same worker, so are handled in the order they were published.  If zero, one worker is used.
+	Workers int
+}
+
+// EventBus routes events to subscribers.  Each subscriber has its own buffer and workers, so a slow subscriber
+// does not hold up the others.  Publish can be used directly as an EventHandler for a WebhookHandler or Stream.
+type EventBus struct {
+	// ErrorLog is used to log handler errors and panics.  If nil, they are not logged.
+	ErrorLog *log.Logger
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu     sync.RWMutex
+	subs   []*BusSubscription
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// BusSubscription is a subscriber to an EventBus.
+type BusSubscription struct {
+	bus    *EventBus
+	types  map[EventType]bool
+	opts   SubscribeOptions
+	fn     EventHandler
+	queues []chan Event
+
+	// sendMu is held for reading while sending to the queues, and for writing to set closed, so that
+	// the queues are only closed once no send is in progress.  done wakes senders blocked on a full queue.
+	sendMu   sync.RWMutex
+	closed   bool
+	done     chan struct{}
+	closeOne sync.Once
+
+	mu      sync.Mutex
+	dropped int
+}
+
+// NewEventBus returns an empty EventBus.
+func NewEventBus() *EventBus {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &EventBus{ctx: ctx, cancel: cancel}
+}
+
+// Subscribe registers fn for events of the given types, or for every event if no types are given.
+// Options may be nil to use the defaults.
+func (b *EventBus) Subscribe(fn EventHandler, opts *SubscribeOptions, types ...EventType) *BusSubscription {
+	s := &BusSubscription{bus: b, fn: fn, done: make(chan struct{})}
+	if opts != nil {
+		s.opts = *opts
+	}
+	if s.opts.Buffer <= 0 {
+		s.opts.Buffer = DefaultBusBuffer
+	}
+	if s.opts.Workers <= 0 {
+		s.opts.Workers = 1
+	}
+	if len(types) > 0 {
+		s.types = make(map[EventType]bool, len(types))
+		for _, t := range types {
+			s.types[t] = true
+		}
+	}
+	s.queues = make([]chan Event, s.opts.Workers)
+	for i := range s.queues {
+		s.queues[i] = make(chan Event, s.opts.Buffer)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		s.close()
+		return s
+	}
+	b.subs = append(b.subs, s)
+	for _, q := range s.queues {
+		b.wg.Add(1)
+		go b.run(s, q)
+	}
+	return s
+}
+
+// OnDeviceLocationUpdate registers fn for EventDeviceLocationUpdate events.
+func (b *EventBus) OnDeviceLocationUpdate(fn func(ctx context.Context, ev *DeviceEvent) error, opts *SubscribeOptions) *BusSubscription {
+	return b.Subscribe(deviceHandler(fn), opts, EventDeviceLocationUpdate)
+}
+
+// OnDeviceEntry registers fn for EventDeviceEntry events.
+func (b *EventBus) OnDeviceEntry(fn func(ctx context.Context, ev *DeviceEvent) error, opts *SubscribeOptions) *BusSubscription {
+	return b.Subscribe(deviceHandler(fn), opts, EventDeviceEntry)
+}
+
+// OnDeviceExit registers fn for EventDeviceExit events.
+func (b *EventBus) OnDeviceExit(fn func(ctx context.Context, ev *DeviceEvent) error, opts *SubscribeOptions) *BusSubscription {
+	return b.Subscribe(deviceHandler(fn), opts, EventDeviceExit)
+}
+
+// OnAPUp registers fn for EventAPUp events.
+func (b *EventBus) OnAPUp(fn func(ctx context.Context, ev *APEvent) error, opts *SubscribeOptions) *BusSubscription {
+	return b.Subscribe(apHandler(fn), opts, EventAPUp)
+}
+
+// OnAPDown registers fn for EventAPDown events.
+func (b *EventBus) OnAPDown(fn func(ctx context.Context, ev *APEvent) error, opts *SubscribeOptions) *BusSubscription {
+	return b.Subscribe(apHandler(fn), opts, EventAPDown)
+}
+
+// deviceHandler adapts a typed handler to an EventHandler, ignoring events of other types.
+func deviceHandler(fn func(ctx context.Context, ev *DeviceEvent) error) EventHandler {
+	return func(ctx context.Context, ev Event) error {
+		if e, ok := ev.(*DeviceEvent); ok {
+			return fn(ctx, e)
+		}
+		return nil
+	}
+}
+
+// apHandler adapts a typed handler to an EventHandler, ignoring events of other types.
+func apHandler(fn func(ctx context.Context, ev *APEvent) error) EventHandler {
+	return func(ctx context.Context, ev Event) error {
+		if e, ok := ev.(*APEvent); ok {
+			return fn(ctx, e)
+		}
+		return nil
+	}
+}
+
+// Publish queues the event for every matching subscriber.  It returns ErrBusClosed once the bus is closed,
+// ErrBufferFull if a subscriber using OverflowError is full, or the context's error if a subscriber using
+// OverflowBlock is full when the context is done.  The event is still queued for the other subscribers.
+// Publish doesn't hold any lock while it waits, so handlers may Subscribe and Unsubscribe, and Close
+// wakes a blocked Publish, which then returns ErrBusClosed.
+func (b *EventBus) Publish(ctx context.Context, ev Event) error {
+	b.mu.RLock()
+	if b.closed {
+		b.mu.RUnlock()
+		return ErrBusClosed
+	}
+	subs := append([]*BusSubscription(nil), b.subs...)
+	b.mu.RUnlock()
+	var first error
+	for _, s := range subs {
+		if err := s.enqueue(ctx, ev); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// enqueue adds the event to the queue of the worker for its device.
+func (s *BusSubscription) enqueue(ctx context.Context, ev Event) error {
+	if s.types != nil && !s.types[ev.Header().Type] {
+		return nil
+	}
+	if !s.opts.Filter.Match(ev) {
+		return nil
+	}
+	q := s.queues[0]
+	if len(s.queues) > 1 {
+		h := fnv.New32a()
+		h.Write([]byte(strings.ToLower(eventKey(ev))))
+		q = s.queues[h.Sum32()%uint32(len(s.queues))]
+	}
+	s.sendMu.RLock()
+	defer s.sendMu.RUnlock()
+	if s.closed {
+		// Unsubscribed since Publish took its snapshot.
+		return nil
+	}
+	select {
+	case q <- ev:
+		return nil
+	default:
+	}
+	switch s.opts.Overflow {
+	case OverflowBlock:
+		select {
+		case q <- ev:
+			return nil
+		case <-s.done:
+			s.bus.mu.RLock()
+			defer s.bus.mu.RUnlock()
+			if s.bus.closed {
+				return ErrBusClosed
+			}
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	case OverflowError:
+		s.drop()
+		return ErrBufferFull
+	}
+	s.drop()
+	return nil
+}
+
+// eventKey returns the mac address used to keep the events of a device in order.
+func eventKey(ev Event) string {
+	switch e := ev.(type) {
+	case *DeviceEvent:
+		return e.Device.MacAddress
+	case *APEvent:
+		return e.AccessPoint.ApMac
+	}
+	return ev.Header().ID
+}
+
+func (s *BusSubscription) drop() {
+	s.mu.Lock()
+	s.dropped++
+	s.mu.Unlock()
+}
+
+// Dropped returns the number of events discarded because the buffer was full.
+func (s *BusSubscription) Dropped() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
+
+// Unsubscribe stops delivering new events to the subscriber.  Events already queued are still handled.
+// It may be called from the subscriber's own handler.
+func (s *BusSubscription) Unsubscribe() {
+	b := s.bus
+	b.mu.Lock()
+	for i, sub := range b.subs {
+		if sub == s {
+			b.subs = append(b.subs[:i:i], b.subs[i+1:]...)
+			break
+		}
+	}
+	b.mu.Unlock()
+	s.close()
+}
+
+// close wakes any blocked senders, waits for sends in progress, and then closes the queues so the workers
+// exit once they are drained.
+func (s *BusSubscription) close() {
+	s.closeOne.Do(func() {
+		close(s.done)
+		s.sendMu.Lock()
+		s.closed = true
+		s.sendMu.Unlock()
+		for _, q := range s.queues {
+			close(q)
+		}
+	})
+}
+
+// run handles the events in a queue until it is closed.
+func (b *EventBus) run(s *BusSubscription, q chan Event) {
+	defer b.wg.Done()
+	for ev := range q {
+		if err := b.call(s.fn, ev); err != nil {
+			b.logf("dnas: event bus: handling event %s: %v", ev.Header().ID, err)
+		}
+	}
+}
+
+// call runs the handler, converting a panic into an error.
+func (b *EventBus) call(fn EventHandler, ev Event) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v\n%s", p, debug.Stack())
+		}
+	}()
+	return fn(b.ctx, ev)
+}
+
+// Close stops accepting events and waits for the events already queued to be handled.
+// If the context is done first, the context passed to handlers is cancelled and the context's error is returned.
+func (b *EventBus) Close(ctx context.Context) error {
+	b.mu.Lock()
+	subs := b.subs
+	b.closed = true
+	b.subs = nil
+	b.mu.Unlock()
+	for _, s := range subs {
+		s.close()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		b.cancel()
+		return nil
+	case <-ctx.Done():
+		b.cancel()
+		return ctx.Err()
+	}
+}
+
+// logf logs to ErrorLog, if set.
+func (b *EventBus) logf(format string, args ...interface{}) {
+	if b.ErrorLog != nil {
+		b.ErrorLog.Printf(format, args...)
+	}
+}
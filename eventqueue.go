@@ -0,0 +1,501 @@
+package dnas
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultSegmentSize is the size at which an EventQueue starts a new segment file when none is given.
+const DefaultSegmentSize = 16 << 20
+
+// segmentExt is the extension of segment files, which are named by the offset of their first record.
+const segmentExt = ".wal"
+
+// committedFile holds the offset of the next event to be handled.
+const committedFile = "committed"
+
+// runCommitBatch is the most events Run handles before committing them.
+const runCommitBatch = 100
+
+// EventQueueOptions represent the options for OpenEventQueue()
+type EventQueueOptions struct {
+	// SegmentSize is the size at which a new segment file is started.  If zero, DefaultSegmentSize is used.
+	SegmentSize int64
+
+	// NoSync skips syncing the segment to disk after each append, and the committed offset after each commit.
+	// This is faster, but events may be lost or delivered again if the machine, rather than just the process, crashes.
+	NoSync bool
+
+	// MaxAttempts is the number of times Run passes an event to its handler before giving up on it.
+	// If zero, Run retries until the handler succeeds.
+	MaxAttempts int
+
+	// DeadLetter, if set, is called by Run with each event it gives up on, and the last error.  Events that can't
+	// be decoded, or were lost to a corrupt record, are given up on straight away with a nil event.  The event is
+	// committed after DeadLetter returns.
+	DeadLetter func(offset uint64, ev Event, err error)
+}
+
+// EventQueue is a write-ahead log of events stored in a directory, with no external broker required.
+// Events are appended to segment files and identified by an offset, starting at zero and increasing by one for each
+// event.  Handled events are committed, and segments containing only committed events are removed by Compact.
+// Set it as the Queue of a WebhookHandler so events are persisted before the notification is acknowledged, and
+// use Run to deliver them to handlers with at-least-once semantics.
+type EventQueue struct {
+	dir  string
+	opts EventQueueOptions
+
+	mu        sync.Mutex
+	segments  []uint64
+	active    *os.File
+	size      int64
+	next      uint64
+	committed uint64
+	closed    bool
+	notify    chan struct{}
+}
+
+// OpenEventQueue opens the queue in dir, creating the directory if needed.  A partially written event at the end of
+// the queue, left by a crash while appending, is discarded.  If the committed offset is missing or can't be read,
+// every event still on disk is delivered again, which at-least-once delivery allows.  Options may be nil to use the
+// defaults.
+func OpenEventQueue(dir string, opts *EventQueueOptions) (*EventQueue, error) {
+	q := &EventQueue{dir: dir, notify: make(chan struct{}, 1)}
+	if opts != nil {
+		q.opts = *opts
+	}
+	if q.opts.SegmentSize <= 0 {
+		q.opts.SegmentSize = DefaultSegmentSize
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(dir, committedFile)); err == nil {
+		// An empty or corrupt file, left by a crash while committing, is treated as nothing committed.
+		q.committed, _ = strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), segmentExt) {
+			continue
+		}
+		base, err := strconv.ParseUint(strings.TrimSuffix(f.Name(), segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		q.segments = append(q.segments, base)
+	}
+	sort.Slice(q.segments, func(i, j int) bool { return q.segments[i] < q.segments[j] })
+
+	if len(q.segments) == 0 {
+		if err := q.rotate(q.committed); err != nil {
+			return nil, err
+		}
+		return q, nil
+	}
+
+	// Recover the last segment, truncating any partial record.
+	base := q.segments[len(q.segments)-1]
+	f, err := os.OpenFile(q.segmentPath(base), os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	count, valid, err := scanSegment(f, nil)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Truncate(valid); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(valid, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	q.active, q.size, q.next = f, valid, base+count
+	if q.committed > q.next {
+		q.committed = q.next
+	}
+	return q, nil
+}
+
+// segmentPath returns the path of the segment starting at base.
+func (q *EventQueue) segmentPath(base uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d%s", base, segmentExt))
+}
+
+// rotate closes the active segment and starts a new one at base.  It must be called with mu held.
+func (q *EventQueue) rotate(base uint64) error {
+	if q.active != nil {
+		if err := q.active.Close(); err != nil {
+			return err
+		}
+		q.active = nil
+	}
+	f, err := os.OpenFile(q.segmentPath(base), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	q.active, q.size, q.next = f, 0, base
+	if len(q.segments) == 0 || q.segments[len(q.segments)-1] != base {
+		q.segments = append(q.segments, base)
+	}
+	return nil
+}
+
+// Append persists the events and returns the offset of the last one.  The events are synced to disk before
+// Append returns, unless NoSync is set.
+func (q *EventQueue) Append(events []Event) (uint64, error) {
+	if len(events) == 0 {
+		return 0, nil
+	}
+	var buf []byte
+	for _, ev := range events {
+		data, err := encodeEvent(ev)
+		if err != nil {
+			return 0, err
+		}
+		var header [8]byte
+		binary.BigEndian.PutUint32(header[0:4], uint32(len(data)))
+		binary.BigEndian.PutUint32(header[4:8], crc32.ChecksumIEEE(data))
+		buf = append(buf, header[:]...)
+		buf = append(buf, data...)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return 0, ErrQueueClosed
+	}
+	if q.size > 0 && q.size+int64(len(buf)) > q.opts.SegmentSize {
+		if err := q.rotate(q.next); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := q.active.Write(buf); err != nil {
+		return 0, err
+	}
+	if !q.opts.NoSync {
+		if err := q.active.Sync(); err != nil {
+			return 0, err
+		}
+	}
+	q.size += int64(len(buf))
+	q.next += uint64(len(events))
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return q.next - 1, nil
+}
+
+// encodeEvent returns the JSON for the event.  Unknown events are stored exactly as they were received.
+func encodeEvent(ev Event) ([]byte, error) {
+	if u, ok := ev.(*UnknownEvent); ok && len(u.Raw) > 0 {
+		return u.Raw, nil
+	}
+	return json.Marshal(ev)
+}
+
+// Replay calls fn for each event from the given offset up to the last event appended when Replay was called.
+// It stops at the first error returned by fn, or at an event that can't be decoded.  If a segment other than the
+// last has a corrupt record, the events from there to the end of the segment are lost, and an error wrapping
+// ErrCorruptEvent is returned at the first of them.
+func (q *EventQueue) Replay(from uint64, fn func(offset uint64, ev Event) error) error {
+	return q.replay(from, func(offset uint64, data []byte) error {
+		if data == nil {
+			return fmt.Errorf("dnas: event at offset %d: %w", offset, ErrCorruptEvent)
+		}
+		ev, err := decodeEvent(data)
+		if err != nil {
+			return fmt.Errorf("dnas: decoding event at offset %d: %w", offset, err)
+		}
+		return fn(offset, ev)
+	})
+}
+
+// replay calls fn with the JSON of each event from the given offset, as for Replay.  Events lost to a corrupt
+// record in a segment other than the last are passed to fn with nil data.
+func (q *EventQueue) replay(from uint64, fn func(offset uint64, data []byte) error) error {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return ErrQueueClosed
+	}
+	end := q.next
+	segments := append([]uint64(nil), q.segments...)
+	q.mu.Unlock()
+
+	for i, base := range segments {
+		if i+1 < len(segments) && segments[i+1] <= from {
+			continue
+		}
+		if base >= end {
+			break
+		}
+		f, err := os.Open(q.segmentPath(base))
+		if os.IsNotExist(err) {
+			// Removed by Compact since the segments were listed.
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		offset := base
+		_, _, err = scanSegment(f, func(data []byte) error {
+			if offset >= end {
+				return io.EOF
+			}
+			current := offset
+			offset++
+			if current < from {
+				return nil
+			}
+			return fn(current, data)
+		})
+		f.Close()
+		if err == io.EOF {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		// A segment ending before the next one starts has lost the events in between.
+		if i+1 < len(segments) {
+			for limit := segments[i+1]; offset < limit && offset < end; offset++ {
+				if offset < from {
+					continue
+				}
+				if err := fn(offset, nil); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// scanSegment reads the records in a segment, calling fn for each if it is not nil.  It returns the number of
+// complete records and the size they take.  Reading stops without error at a partial or corrupt record.
+func scanSegment(f *os.File, fn func(data []byte) error) (uint64, int64, error) {
+	r := bufio.NewReader(f)
+	var count uint64
+	var size int64
+	var header [8]byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return count, size, nil
+		}
+		data := make([]byte, binary.BigEndian.Uint32(header[0:4]))
+		if _, err := io.ReadFull(r, data); err != nil {
+			return count, size, nil
+		}
+		if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(header[4:8]) {
+			return count, size, nil
+		}
+		if fn != nil {
+			if err := fn(data); err != nil {
+				return count, size, err
+			}
+		}
+		count++
+		size += int64(len(header) + len(data))
+	}
+}
+
+// Commit records that every event up to and including offset has been handled.
+func (q *EventQueue) Commit(offset uint64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return ErrQueueClosed
+	}
+	next := offset + 1
+	if next > q.next {
+		next = q.next
+	}
+	if next <= q.committed {
+		return nil
+	}
+	tmp := filepath.Join(q.dir, committedFile+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(strconv.FormatUint(next, 10)); err != nil {
+		f.Close()
+		return err
+	}
+	if !q.opts.NoSync {
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(q.dir, committedFile)); err != nil {
+		return err
+	}
+	if !q.opts.NoSync {
+		syncDir(q.dir)
+	}
+	q.committed = next
+	return nil
+}
+
+// syncDir syncs a directory so that a rename within it is durable.  It is best effort, as not every
+// platform supports syncing a directory, and a lost rename only causes events to be delivered again.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
+
+// Committed returns the offset of the first event that has not been handled.
+func (q *EventQueue) Committed() uint64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.committed
+}
+
+// Len returns the number of events that have not been handled.
+func (q *EventQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return int(q.next - q.committed)
+}
+
+// Compact removes the segments whose events have all been committed.  The active segment is never removed.
+func (q *EventQueue) Compact() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.segments) > 1 && q.segments[1] <= q.committed {
+		if err := os.Remove(q.segmentPath(q.segments[0])); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		q.segments = q.segments[1:]
+	}
+	return nil
+}
+
+// Run delivers events from the committed offset to fn, waiting for new events once the queue is empty.  If fn
+// returns an error the event is retried, with a delay increasing up to a minute, so events are delivered at least
+// once.  After MaxAttempts, if set, the event is passed to DeadLetter and skipped, as are events that can't be decoded
+// or were lost to a corrupt record.  Run returns when the context is done or the queue is closed.
+//
+// Handled events are committed in batches of up to 100, and before Run waits for new events or retries, so that the
+// committed offset isn't synced to disk for every event.  After a crash, up to a batch of events may be delivered again.
+func (q *EventQueue) Run(ctx context.Context, fn EventHandler) error {
+	backoff := time.Second
+	var failedOffset uint64
+	attempts := 0
+	for {
+		var last uint64
+		handled, uncommitted := false, 0
+		done := func(offset uint64) error {
+			attempts = 0
+			last, handled = offset, true
+			if uncommitted++; uncommitted < runCommitBatch {
+				return nil
+			}
+			uncommitted = 0
+			return q.Commit(offset)
+		}
+		giveUp := func(offset uint64, ev Event, err error) error {
+			if q.opts.DeadLetter != nil {
+				q.opts.DeadLetter(offset, ev, err)
+			}
+			return done(offset)
+		}
+		err := q.replay(q.Committed(), func(offset uint64, data []byte) error {
+			if data == nil {
+				return giveUp(offset, nil, fmt.Errorf("dnas: event at offset %d: %w", offset, ErrCorruptEvent))
+			}
+			ev, err := decodeEvent(data)
+			if err != nil {
+				return giveUp(offset, nil, fmt.Errorf("dnas: decoding event at offset %d: %w", offset, err))
+			}
+			if err := fn(ctx, ev); err != nil {
+				if attempts == 0 || offset != failedOffset {
+					failedOffset, attempts = offset, 0
+				}
+				attempts++
+				if q.opts.MaxAttempts > 0 && attempts >= q.opts.MaxAttempts {
+					return giveUp(offset, ev, err)
+				}
+				return err
+			}
+			return done(offset)
+		})
+		if err == ErrQueueClosed {
+			return err
+		}
+		if handled {
+			if err := q.Commit(last); err != nil {
+				return err
+			}
+			if err := q.Compact(); err != nil {
+				return err
+			}
+		}
+		wait := time.Duration(-1)
+		if err != nil {
+			wait = backoff
+			if backoff *= 2; backoff > time.Minute {
+				backoff = time.Minute
+			}
+		} else {
+			backoff = time.Second
+		}
+		if wait < 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-q.notify:
+			}
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Close closes the active segment.  Events not yet committed are delivered again when the queue is next opened.
+func (q *EventQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return nil
+	}
+	q.closed = true
+	close(q.notify)
+	return q.active.Close()
+}
@@ -0,0 +1,280 @@
+package dnas
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func openTestQueue(t *testing.T, dir string, opts *EventQueueOptions) *EventQueue {
+	t.Helper()
+	q, err := OpenEventQueue(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func appendEvents(t *testing.T, q *EventQueue, from, to int) {
+	t.Helper()
+	for i := from; i < to; i++ {
+		if _, err := q.Append([]Event{deviceEvent(strconv.Itoa(i), "a", int64(i))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// replayIDs returns the offsets and event IDs replayed from the given offset.
+func replayIDs(t *testing.T, q *EventQueue, from uint64) ([]uint64, []string) {
+	t.Helper()
+	var offsets []uint64
+	var ids []string
+	err := q.Replay(from, func(offset uint64, ev Event) error {
+		offsets = append(offsets, offset)
+		ids = append(ids, ev.Header().ID)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return offsets, ids
+}
+
+func segmentFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestQueueReopen(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "queue")
+	defer os.RemoveAll(dir)
+
+	q := openTestQueue(t, dir, nil)
+	appendEvents(t, q, 0, 5)
+	if err := q.Commit(2); err != nil {
+		t.Fatal(err)
+	}
+	q.Close()
+
+	q = openTestQueue(t, dir, nil)
+	defer q.Close()
+	if q.Committed() != 3 || q.Len() != 2 {
+		t.Errorf("committed %d with %d left, want 3 with 2 left", q.Committed(), q.Len())
+	}
+	offsets, ids := replayIDs(t, q, q.Committed())
+	if len(offsets) != 2 || offsets[0] != 3 || ids[1] != "4" {
+		t.Errorf("replayed %v %q, want offsets 3 and 4", offsets, ids)
+	}
+	if last, err := q.Append([]Event{deviceEvent("5", "a", 5)}); err != nil || last != 5 {
+		t.Errorf("Append = %d, %v, want offset 5", last, err)
+	}
+}
+
+func TestQueueTornRecord(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "queue")
+	defer os.RemoveAll(dir)
+
+	q := openTestQueue(t, dir, nil)
+	appendEvents(t, q, 0, 3)
+	q.Close()
+
+	// A crash while appending leaves a header and part of the event.
+	files := segmentFiles(t, dir)
+	f, err := os.OpenFile(files[len(files)-1], os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 1, 0, 1, 2, 3, 4, '{', '"'})
+	f.Close()
+
+	q = openTestQueue(t, dir, nil)
+	defer q.Close()
+	if q.Len() != 3 {
+		t.Errorf("Len = %d after reopening, want 3", q.Len())
+	}
+	appendEvents(t, q, 3, 4)
+	if _, ids := replayIDs(t, q, 0); len(ids) != 4 || ids[3] != "3" {
+		t.Errorf("replayed %q, want 4 events", ids)
+	}
+}
+
+func TestQueueCorruptCommitted(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "queue")
+	defer os.RemoveAll(dir)
+
+	q := openTestQueue(t, dir, nil)
+	appendEvents(t, q, 0, 3)
+	q.Commit(1)
+	q.Close()
+	if err := ioutil.WriteFile(filepath.Join(dir, committedFile), []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	q = openTestQueue(t, dir, nil)
+	defer q.Close()
+	if q.Committed() != 0 || q.Len() != 3 {
+		t.Errorf("committed %d with %d left, want every event delivered again", q.Committed(), q.Len())
+	}
+}
+
+func TestQueueSegments(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "queue")
+	defer os.RemoveAll(dir)
+
+	opts := &EventQueueOptions{SegmentSize: 300}
+	q := openTestQueue(t, dir, opts)
+	appendEvents(t, q, 0, 10)
+	if n := len(segmentFiles(t, dir)); n < 3 {
+		t.Fatalf("%d segments, want the queue to rotate", n)
+	}
+	offsets, _ := replayIDs(t, q, 0)
+	for i, offset := range offsets {
+		if offset != uint64(i) {
+			t.Fatalf("replayed offsets %v, want 0 to 9", offsets)
+		}
+	}
+	if _, ids := replayIDs(t, q, 7); len(ids) != 3 || ids[0] != "7" {
+		t.Errorf("replayed %q from 7, want [7 8 9]", ids)
+	}
+
+	q.Commit(9)
+	if err := q.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(segmentFiles(t, dir)); n != 1 {
+		t.Errorf("%d segments after compacting, want only the active segment", n)
+	}
+	q.Close()
+
+	q = openTestQueue(t, dir, opts)
+	defer q.Close()
+	if last, err := q.Append([]Event{deviceEvent("10", "a", 10)}); err != nil || last != 10 {
+		t.Errorf("Append = %d, %v after compacting, want offset 10", last, err)
+	}
+}
+
+func TestQueueCorruptSegment(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "queue")
+	defer os.RemoveAll(dir)
+
+	q := openTestQueue(t, dir, &EventQueueOptions{SegmentSize: 300})
+	defer q.Close()
+	appendEvents(t, q, 0, 10)
+
+	// Corrupt the first event of the first segment, losing the rest of that segment.
+	files := segmentFiles(t, dir)
+	b, err := ioutil.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[10] ^= 0xff
+	if err := ioutil.WriteFile(files[0], b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err = q.Replay(0, func(offset uint64, ev Event) error { return nil })
+	if !errors.Is(err, ErrCorruptEvent) {
+		t.Errorf("Replay err = %v, want ErrCorruptEvent", err)
+	}
+
+	var mu sync.Mutex
+	var dead []uint64
+	var ids []string
+	q.opts.DeadLetter = func(offset uint64, ev Event, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if ev != nil || !errors.Is(err, ErrCorruptEvent) {
+			t.Errorf("dead letter %d = %v, %v, want a corrupt event", offset, ev, err)
+		}
+		dead = append(dead, offset)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.Run(ctx, func(ctx context.Context, ev Event) error {
+		mu.Lock()
+		defer mu.Unlock()
+		ids = append(ids, ev.Header().ID)
+		return nil
+	})
+	waitFor(t, func() bool { return q.Len() == 0 })
+	mu.Lock()
+	defer mu.Unlock()
+	if len(dead) == 0 || dead[0] != 0 || len(dead)+len(ids) != 10 || ids[0] != strconv.FormatUint(dead[len(dead)-1]+1, 10) {
+		t.Errorf("dead letters %v and delivered %q, want the lost events given up and the rest delivered", dead, ids)
+	}
+}
+
+func TestQueueRun(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "queue")
+	defer os.RemoveAll(dir)
+
+	var mu sync.Mutex
+	var dead []string
+	var delivered []string
+	var attempts []time.Time
+	q := openTestQueue(t, dir, &EventQueueOptions{
+		MaxAttempts: 2,
+		DeadLetter: func(offset uint64, ev Event, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			dead = append(dead, ev.Header().ID)
+		},
+	})
+	defer q.Close()
+	appendEvents(t, q, 0, 3)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.Run(ctx, func(ctx context.Context, ev Event) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if ev.Header().ID == "1" {
+			attempts = append(attempts, time.Now())
+			return errors.New("failed")
+		}
+		delivered = append(delivered, ev.Header().ID)
+		return nil
+	})
+	waitFor(t, func() bool { return q.Len() == 0 })
+
+	// Events appended while running are delivered.
+	appendEvents(t, q, 3, 4)
+	waitFor(t, func() bool { return q.Len() == 0 })
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(delivered) != 3 || delivered[0] != "0" || delivered[1] != "2" || delivered[2] != "3" {
+		t.Errorf("delivered %q, want [0 2 3]", delivered)
+	}
+	if len(dead) != 1 || dead[0] != "1" {
+		t.Errorf("dead letters %q, want [1]", dead)
+	}
+	if len(attempts) != 2 || attempts[1].Sub(attempts[0]) < time.Second {
+		t.Errorf("%d attempts, want 2 with a backoff of a second between them", len(attempts))
+	}
+	if q.Committed() != 4 {
+		t.Errorf("committed %d, want 4", q.Committed())
+	}
+}
+
+// waitFor polls until cond is true, failing the test if it takes too long.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,223 @@
+// Package floorplan renders DNA Spaces floors with device positions as SVG or PNG.
+//
+// Devices are drawn as markers coloured by device type or associated state, optionally with a circle showing the
+// confidence factor of the location.  The floor image is used as the background when provided.
+// Only the standard library is used.
+package floorplan
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"math"
+
+	"github.com/darrenparkinson/dnas"
+)
+
+// ColorBy selects how device markers are coloured.
+type ColorBy int
+
+// Fields for ColorBy
+const (
+	ByDeviceType ColorBy = iota
+	ByAssociation
+)
+
+// Default marker colours.
+var (
+	DeviceTypeColors = map[string]color.NRGBA{
+		"CLIENT":       {R: 0x1f, G: 0x77, B: 0xb4, A: 0xff},
+		"TAG":          {R: 0x2c, G: 0xa0, B: 0x2c, A: 0xff},
+		"ROGUE_AP":     {R: 0xd6, G: 0x27, B: 0x28, A: 0xff},
+		"ROGUE_CLIENT": {R: 0xff, G: 0x7f, B: 0x0e, A: 0xff},
+		"INTERFERER":   {R: 0x94, G: 0x67, B: 0xbd, A: 0xff},
+	}
+	AssociatedColor   = color.NRGBA{R: 0x2c, G: 0xa0, B: 0x2c, A: 0xff}
+	UnassociatedColor = color.NRGBA{R: 0x7f, G: 0x7f, B: 0x7f, A: 0xff}
+	DefaultColor      = color.NRGBA{R: 0x7f, G: 0x7f, B: 0x7f, A: 0xff}
+)
+
+// Options control how a floor is rendered.
+type Options struct {
+	// Width of the output in pixels when there is no floor image.  If zero, 1000 is used.
+	// When a floor image is given, the output is the size of the image.
+	Width int
+
+	// FloorImage, if set, is drawn as the background.  It is taken to cover the floor's width and length, so devices
+	// are scaled to the size of the image.
+	FloorImage image.Image
+
+	// ColorBy selects how markers are coloured.
+	ColorBy ColorBy
+
+	// Colors overrides the marker colour for device types when colouring by device type.
+	Colors map[string]color.NRGBA
+
+	// MarkerRadius is the radius of each marker in pixels.  If zero, 5 is used.
+	MarkerRadius float64
+
+	// Confidence draws a circle around each marker with a radius of the device's confidence factor in floor units.
+	Confidence bool
+}
+
+// Renderer draws devices on a single floor.
+type Renderer struct {
+	floor  dnas.MapItem
+	opts   Options
+	width  int
+	height int
+	scaleX float64
+	scaleY float64
+}
+
+// marker is a device prepared for drawing, in pixels.
+type marker struct {
+	x, y       float64
+	radius     float64
+	confidence float64
+	color      color.NRGBA
+	label      string
+}
+
+// New returns a Renderer for the floor.  The floor must have a finite width and length.
+func New(floor dnas.MapItem, opts Options) (*Renderer, error) {
+	d := floor.Details
+	if d.Width <= 0 || d.Length <= 0 || !finite(d.Width, d.Length, d.OffsetX, d.OffsetY) {
+		return nil, errors.New("floorplan: floor width and length required")
+	}
+	r := &Renderer{floor: floor, opts: opts}
+	if opts.FloorImage != nil {
+		b := opts.FloorImage.Bounds()
+		r.width, r.height = b.Dx(), b.Dy()
+	} else {
+		r.width = opts.Width
+		if r.width <= 0 {
+			r.width = 1000
+		}
+		r.height = int(math.Round(float64(r.width) * d.Length / d.Width))
+	}
+	r.scaleX = float64(r.width) / d.Width
+	r.scaleY = float64(r.height) / d.Length
+	if r.opts.MarkerRadius <= 0 || !finite(r.opts.MarkerRadius) {
+		r.opts.MarkerRadius = 5
+	}
+	return r, nil
+}
+
+// markers converts the devices on this floor to pixel positions, skipping devices whose position isn't finite.
+func (r *Renderer) markers(devices []dnas.LocationDevice) []marker {
+	var markers []marker
+	for _, dev := range devices {
+		if r.floor.ID != "" && dev.FloorID != "" && dev.FloorID != r.floor.ID {
+			continue
+		}
+		if len(dev.Coordinates) < 2 {
+			continue
+		}
+		m := marker{
+			x:      (dev.Coordinates[0] - r.floor.Details.OffsetX) * r.scaleX,
+			y:      (dev.Coordinates[1] - r.floor.Details.OffsetY) * r.scaleY,
+			radius: r.opts.MarkerRadius,
+			color:  r.color(dev),
+			label:  dev.MacAddress,
+		}
+		if !finite(m.x, m.y) {
+			continue
+		}
+		if r.opts.Confidence && dev.ConfidenceFactor > 0 {
+			m.confidence = float64(dev.ConfidenceFactor) * (r.scaleX + r.scaleY) / 2
+		}
+		markers = append(markers, m)
+	}
+	return markers
+}
+
+// finite reports whether none of the values are NaN or infinite.
+func finite(values ...float64) bool {
+	for _, v := range values {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// color returns the marker colour for the device.
+func (r *Renderer) color(dev dnas.LocationDevice) color.NRGBA {
+	if r.opts.ColorBy == ByAssociation {
+		if dev.Associated {
+			return AssociatedColor
+		}
+		return UnassociatedColor
+	}
+	if c, ok := r.opts.Colors[dev.DeviceType]; ok {
+		return c
+	}
+	if c, ok := DeviceTypeColors[dev.DeviceType]; ok {
+		return c
+	}
+	return DefaultColor
+}
+
+// Image draws the floor and devices.
+func (r *Renderer) Image(devices []dnas.LocationDevice) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, r.width, r.height))
+	if r.opts.FloorImage != nil {
+		draw.Draw(dst, dst.Bounds(), r.opts.FloorImage, r.opts.FloorImage.Bounds().Min, draw.Src)
+	} else {
+		draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	}
+	markers := r.markers(devices)
+	for _, m := range markers {
+		if m.confidence > 0 {
+			fill := m.color
+			fill.A = 0x30
+			drawCircle(dst, m.x, m.y, m.confidence, 0, fill)
+			drawCircle(dst, m.x, m.y, m.confidence, 1, m.color)
+		}
+	}
+	for _, m := range markers {
+		drawCircle(dst, m.x, m.y, m.radius, 0, m.color)
+		drawCircle(dst, m.x, m.y, m.radius, 1, color.NRGBA{A: 0xff})
+	}
+	return dst
+}
+
+// WritePNG renders the floor and devices as a PNG.
+func (r *Renderer) WritePNG(w io.Writer, devices []dnas.LocationDevice) error {
+	return png.Encode(w, r.Image(devices))
+}
+
+// drawCircle draws a filled circle, or a ring of the given stroke width in pixels if stroke is greater than zero.
+func drawCircle(dst draw.Image, cx, cy, radius, stroke float64, c color.NRGBA) {
+	mask := &circleMask{cx: cx, cy: cy, r: radius, stroke: stroke}
+	draw.DrawMask(dst, mask.Bounds(), image.NewUniform(c), image.Point{}, mask, mask.Bounds().Min, draw.Over)
+}
+
+// circleMask is an alpha mask for a circle or ring.
+type circleMask struct {
+	cx, cy, r, stroke float64
+}
+
+func (m *circleMask) ColorModel() color.Model { return color.AlphaModel }
+
+func (m *circleMask) Bounds() image.Rectangle {
+	return image.Rect(int(math.Floor(m.cx-m.r-1)), int(math.Floor(m.cy-m.r-1)), int(math.Ceil(m.cx+m.r+1)), int(math.Ceil(m.cy+m.r+1)))
+}
+
+func (m *circleMask) At(x, y int) color.Color {
+	d := math.Hypot(float64(x)+0.5-m.cx, float64(y)+0.5-m.cy)
+	if m.stroke > 0 {
+		if math.Abs(d-m.r) <= m.stroke/2 {
+			return color.Alpha{A: 0xff}
+		}
+		return color.Alpha{}
+	}
+	if d <= m.r {
+		return color.Alpha{A: 0xff}
+	}
+	return color.Alpha{}
+}
@@ -0,0 +1,124 @@
+package floorplan
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/darrenparkinson/dnas"
+)
+
+var testFloor = dnas.MapItem{
+	ID:      "f1",
+	Name:    "Ground <0>",
+	Details: dnas.MapItemDetails{Width: 100, Length: 50, OffsetX: 10, OffsetY: 5},
+}
+
+func TestNew(t *testing.T) {
+	r, err := New(testFloor, Options{Width: 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.width != 200 || r.height != 100 {
+		t.Errorf("size %dx%d, want 200x100", r.width, r.height)
+	}
+
+	// With a floor image, the output is the size of the image.
+	r, err = New(testFloor, Options{Width: 200, FloorImage: image.NewRGBA(image.Rect(0, 0, 300, 300))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.width != 300 || r.height != 300 || r.scaleX != 3 || r.scaleY != 6 {
+		t.Errorf("size %dx%d scaled %vx%v, want 300x300 scaled 3x6", r.width, r.height, r.scaleX, r.scaleY)
+	}
+
+	for _, d := range []dnas.MapItemDetails{
+		{Width: 0, Length: 50},
+		{Width: 100, Length: -1},
+		{Width: math.NaN(), Length: 50},
+		{Width: math.Inf(1), Length: 50},
+		{Width: 100, Length: 50, OffsetX: math.NaN()},
+	} {
+		if _, err := New(dnas.MapItem{Details: d}, Options{}); err == nil {
+			t.Errorf("New(%+v) expected error", d)
+		}
+	}
+}
+
+func TestMarkers(t *testing.T) {
+	r, err := New(testFloor, Options{Width: 200, Confidence: true, Colors: map[string]color.NRGBA{"TAG": {R: 1, A: 0xff}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	markers := r.markers([]dnas.LocationDevice{
+		{MacAddress: "a", FloorID: "f1", Coordinates: []float64{20, 15}, DeviceType: "CLIENT", ConfidenceFactor: 8},
+		{MacAddress: "b", Coordinates: []float64{10, 5}, DeviceType: "TAG"},
+		{MacAddress: "other floor", FloorID: "f2", Coordinates: []float64{20, 15}},
+		{MacAddress: "no coordinates", FloorID: "f1"},
+		{MacAddress: "nan", FloorID: "f1", Coordinates: []float64{math.NaN(), 15}},
+		{MacAddress: "inf", FloorID: "f1", Coordinates: []float64{20, math.Inf(-1)}},
+		{MacAddress: "overflow", FloorID: "f1", Coordinates: []float64{math.MaxFloat64, 15}},
+	})
+	if len(markers) != 2 {
+		t.Fatalf("%d markers, want 2", len(markers))
+	}
+	a, b := markers[0], markers[1]
+	if a.x != 20 || a.y != 20 || a.radius != 5 || a.confidence != 16 || a.color != DeviceTypeColors["CLIENT"] {
+		t.Errorf("marker a = %+v", a)
+	}
+	if b.x != 0 || b.y != 0 || b.confidence != 0 || b.color != (color.NRGBA{R: 1, A: 0xff}) {
+		t.Errorf("marker b = %+v", b)
+	}
+
+	r.opts.ColorBy = ByAssociation
+	if c := r.color(dnas.LocationDevice{Associated: true}); c != AssociatedColor {
+		t.Errorf("associated colour = %v", c)
+	}
+	if c := r.color(dnas.LocationDevice{DeviceType: "TAG"}); c != UnassociatedColor {
+		t.Errorf("unassociated colour = %v", c)
+	}
+}
+
+func TestRender(t *testing.T) {
+	r, err := New(testFloor, Options{Width: 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices := []dnas.LocationDevice{
+		{MacAddress: "a&b", Coordinates: []float64{60, 30}, DeviceType: "ROGUE_AP"},
+		{MacAddress: "nan", Coordinates: []float64{math.NaN(), math.NaN()}},
+	}
+
+	img := r.Image(devices)
+	if c := color.NRGBAModel.Convert(img.At(100, 50)).(color.NRGBA); c != DeviceTypeColors["ROGUE_AP"] {
+		t.Errorf("marker pixel = %v, want the rogue AP colour", c)
+	}
+	if c := color.NRGBAModel.Convert(img.At(0, 0)).(color.NRGBA); c != (color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}) {
+		t.Errorf("background pixel = %v, want white", c)
+	}
+	var buf bytes.Buffer
+	if err := r.WritePNG(&buf, devices); err != nil {
+		t.Fatal(err)
+	}
+
+	buf.Reset()
+	if err := r.WriteSVG(&buf, devices); err != nil {
+		t.Fatal(err)
+	}
+	svg := buf.String()
+	for _, want := range []string{
+		`width="200" height="100"`,
+		"<title>Ground &lt;0&gt;</title>",
+		`<circle cx="100.0" cy="50.0" r="5.0" fill="#d62728" stroke="#000000"><title>a&amp;b</title></circle>`,
+	} {
+		if !strings.Contains(svg, want) {
+			t.Errorf("svg missing %s:\n%s", want, svg)
+		}
+	}
+	if strings.Contains(svg, "NaN") {
+		t.Errorf("svg contains NaN:\n%s", svg)
+	}
+}
@@ -0,0 +1,56 @@
+package floorplan
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"image/color"
+	"image/png"
+	"io"
+
+	"github.com/darrenparkinson/dnas"
+)
+
+// WriteSVG renders the floor and devices as SVG.  The floor image, if any, is embedded as a PNG.
+func (r *Renderer) WriteSVG(w io.Writer, devices []dnas.LocationDevice) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+"\n", r.width, r.height, r.width, r.height)
+	fmt.Fprintf(bw, "<title>%s</title>\n", escape(r.floor.Name))
+	if r.opts.FloorImage != nil {
+		var img bytes.Buffer
+		if err := png.Encode(&img, r.opts.FloorImage); err != nil {
+			return err
+		}
+		fmt.Fprintf(bw, `<image x="0" y="0" width="%d" height="%d" preserveAspectRatio="none" href="data:image/png;base64,%s"/>`+"\n",
+			r.width, r.height, base64.StdEncoding.EncodeToString(img.Bytes()))
+	} else {
+		fmt.Fprintf(bw, `<rect x="0" y="0" width="%d" height="%d" fill="#ffffff"/>`+"\n", r.width, r.height)
+	}
+	markers := r.markers(devices)
+	for _, m := range markers {
+		if m.confidence > 0 {
+			fmt.Fprintf(bw, `<circle cx="%.1f" cy="%.1f" r="%.1f" fill="%s" fill-opacity="0.19" stroke="%s"/>`+"\n",
+				m.x, m.y, m.confidence, hex(m.color), hex(m.color))
+		}
+	}
+	for _, m := range markers {
+		fmt.Fprintf(bw, `<circle cx="%.1f" cy="%.1f" r="%.1f" fill="%s" stroke="#000000"><title>%s</title></circle>`+"\n",
+			m.x, m.y, m.radius, hex(m.color), escape(m.label))
+	}
+	fmt.Fprint(bw, "</svg>\n")
+	return bw.Flush()
+}
+
+// hex formats the colour as an SVG hex colour.
+func hex(c color.NRGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// escape escapes text for inclusion in SVG.
+func escape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
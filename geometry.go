@@ -0,0 +1,34 @@
+package dnas
+
+import "math"
+
+// pointInPolygon reports whether the point lies inside the polygon described by the vertices using the even-odd rule.
+// The polygon is closed implicitly and the vertices must be in the same units as the point.
+func pointInPolygon(x, y float64, vertices []MapItemCorner) bool {
+	inside := false
+	for i, j := 0, len(vertices)-1; i < len(vertices); j, i = i, i+1 {
+		vi, vj := vertices[i], vertices[j]
+		if (vi.Y > y) != (vj.Y > y) && x < (vj.X-vi.X)*(y-vi.Y)/(vj.Y-vi.Y)+vi.X {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// nearestPointOnPolygon returns the point on the boundary of the polygon closest to the given point.
+func nearestPointOnPolygon(x, y float64, vertices []MapItemCorner) (float64, float64) {
+	bestX, bestY, best := x, y, math.Inf(1)
+	for i, j := 0, len(vertices)-1; i < len(vertices); j, i = i, i+1 {
+		ax, ay, bx, by := vertices[j].X, vertices[j].Y, vertices[i].X, vertices[i].Y
+		dx, dy := bx-ax, by-ay
+		t := 0.0
+		if l := dx*dx + dy*dy; l > 0 {
+			t = math.Max(0, math.Min(1, ((x-ax)*dx+(y-ay)*dy)/l))
+		}
+		px, py := ax+t*dx, ay+t*dy
+		if d := math.Hypot(x-px, y-py); d < best {
+			bestX, bestY, best = px, py, d
+		}
+	}
+	return bestX, bestY
+}
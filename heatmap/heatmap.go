@@ -0,0 +1,229 @@
+// Package heatmap provides density heatmaps of device locations on DNA Spaces floor plans.
+//
+// A Grid is created from a floor map element and bins device coordinates into square cells.
+// The grid can be rendered to a PNG, optionally overlaid onto the floor image, or exported as JSON.
+// Only the standard library image packages are used.
+package heatmap
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"io"
+	"math"
+
+	"github.com/darrenparkinson/dnas"
+)
+
+// MaxCells is the largest number of cells a grid may have, limiting the memory used by a small cell size on a large floor.
+const MaxCells = 1 << 22
+
+// Grid holds the binned device counts for a single floor.
+// Coordinates are in the floor's units with the origin at (OffsetX, OffsetY).
+type Grid struct {
+	// FloorID, if set, restricts AddLocationDevices and AddHistoryItems to records on that floor.
+	FloorID string `json:"floorId,omitempty"`
+
+	OffsetX  float64 `json:"offsetX"`
+	OffsetY  float64 `json:"offsetY"`
+	Width    float64 `json:"width"`
+	Length   float64 `json:"length"`
+	CellSize float64 `json:"cellSize"`
+	Cols     int     `json:"cols"`
+	Rows     int     `json:"rows"`
+
+	// Max is the highest value of any cell.
+	Max float64 `json:"max"`
+
+	// Dropped is the number of points that fell outside the floor, had no coordinates or coordinates that couldn't be
+	// parsed, or had a NaN or infinite coordinate or weight.
+	Dropped int `json:"dropped"`
+
+	// Cells holds the value of each cell, indexed by row then column.
+	Cells [][]float64 `json:"cells"`
+}
+
+// RenderOptions control how a Grid is drawn.
+type RenderOptions struct {
+	// Ramp maps the normalised cell value to a colour.  If nil, DefaultRamp is used.
+	Ramp Ramp
+
+	// CellPixels is the size of each cell in pixels when rendering without a floor image.  If zero, 10 is used.
+	CellPixels int
+
+	// Opacity of the heatmap from 0 to 1 when overlaid onto a floor image.  If zero, 0.6 is used.
+	Opacity float64
+}
+
+// New returns an empty grid for the floor with the given cell size in the floor's units.
+func New(floor dnas.MapItem, cellSize float64) (*Grid, error) {
+	g, err := NewFromDetails(floor.Details, cellSize)
+	if err != nil {
+		return nil, err
+	}
+	g.FloorID = floor.ID
+	return g, nil
+}
+
+// NewFromDetails returns an empty grid using the floor dimensions from the map details with the given cell size in the floor's units.
+// An error is returned if the grid would have more than MaxCells cells.
+func NewFromDetails(details dnas.MapItemDetails, cellSize float64) (*Grid, error) {
+	if details.Width <= 0 || details.Length <= 0 || !finite(details.Width) || !finite(details.Length) {
+		return nil, errors.New("heatmap: floor width and length required")
+	}
+	if cellSize <= 0 || !finite(cellSize) {
+		return nil, errors.New("heatmap: cell size must be greater than zero")
+	}
+	cols, rows := math.Ceil(details.Width/cellSize), math.Ceil(details.Length/cellSize)
+	if cols*rows > MaxCells {
+		return nil, fmt.Errorf("heatmap: cell size %g gives %.0f cells, more than %d", cellSize, cols*rows, MaxCells)
+	}
+	g := &Grid{
+		OffsetX:  details.OffsetX,
+		OffsetY:  details.OffsetY,
+		Width:    details.Width,
+		Length:   details.Length,
+		CellSize: cellSize,
+		Cols:     int(cols),
+		Rows:     int(rows),
+	}
+	g.Cells = make([][]float64, g.Rows)
+	for i := range g.Cells {
+		g.Cells[i] = make([]float64, g.Cols)
+	}
+	return g, nil
+}
+
+// Add adds weight to the cell containing the point and reports whether the point was on the floor.
+// Points with a NaN or infinite coordinate or weight are dropped.
+func (g *Grid) Add(x, y, weight float64) bool {
+	if !finite(x) || !finite(y) || !finite(weight) {
+		g.Dropped++
+		return false
+	}
+	fx, fy := x-g.OffsetX, y-g.OffsetY
+	if fx < 0 || fy < 0 || fx > g.Width || fy > g.Length {
+		g.Dropped++
+		return false
+	}
+	col := int(fx / g.CellSize)
+	row := int(fy / g.CellSize)
+	// Points exactly on the far edge belong to the last cell.
+	if col >= g.Cols {
+		col = g.Cols - 1
+	}
+	if row >= g.Rows {
+		row = g.Rows - 1
+	}
+	g.Cells[row][col] += weight
+	if g.Cells[row][col] > g.Max {
+		g.Max = g.Cells[row][col]
+	}
+	return true
+}
+
+// finite reports whether v is neither NaN nor infinite.
+func finite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+// AddLocationDevices adds the coordinates of each device to the grid.
+func (g *Grid) AddLocationDevices(devices []dnas.LocationDevice) {
+	for _, d := range devices {
+		if g.FloorID != "" && d.FloorID != g.FloorID {
+			continue
+		}
+		p, ok := d.Point()
+		if !ok {
+			g.Dropped++
+			continue
+		}
+		g.Add(p.X, p.Y, 1)
+	}
+}
+
+// AddHistoryItems adds the coordinates of each history record to the grid.
+// Records with coordinates that can't be parsed are counted in Dropped.
+func (g *Grid) AddHistoryItems(items []dnas.HistoryItem) {
+	for _, item := range items {
+		if g.FloorID != "" && item.FloorID != g.FloorID {
+			continue
+		}
+		p, err := item.Point()
+		if err != nil {
+			g.Dropped++
+			continue
+		}
+		g.Add(p.X, p.Y, 1)
+	}
+}
+
+// Image renders the grid with each cell drawn as a square of opts.CellPixels pixels.
+// Empty cells are transparent.
+func (g *Grid) Image(opts RenderOptions) *image.NRGBA {
+	px := opts.CellPixels
+	if px <= 0 {
+		px = 10
+	}
+	return g.render(g.Cols*px, g.Rows*px, opts.ramp(), 1)
+}
+
+// Overlay draws the grid over the floor image, scaled to the size of the image.
+func (g *Grid) Overlay(floor image.Image, opts RenderOptions) *image.RGBA {
+	b := floor.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), floor, b.Min, draw.Src)
+	opacity := opts.Opacity
+	if opacity <= 0 {
+		opacity = 0.6
+	}
+	heat := g.render(b.Dx(), b.Dy(), opts.ramp(), opacity)
+	draw.Draw(dst, dst.Bounds(), heat, image.Point{}, draw.Over)
+	return dst
+}
+
+// WritePNG renders the grid as a PNG.  If floor is not nil, the grid is overlaid onto it.
+func (g *Grid) WritePNG(w io.Writer, floor image.Image, opts RenderOptions) error {
+	if floor != nil {
+		return png.Encode(w, g.Overlay(floor, opts))
+	}
+	return png.Encode(w, g.Image(opts))
+}
+
+// WriteJSON writes the raw grid as JSON.
+func (g *Grid) WriteJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(g)
+}
+
+// render draws the grid scaled to w by h pixels.
+func (g *Grid) render(w, h int, ramp Ramp, opacity float64) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	if g.Max == 0 || w == 0 || h == 0 {
+		return img
+	}
+	for py := 0; py < h; py++ {
+		row := py * g.Rows / h
+		for px := 0; px < w; px++ {
+			col := px * g.Cols / w
+			v := g.Cells[row][col]
+			if v == 0 {
+				continue
+			}
+			c := ramp.At(v / g.Max)
+			c.A = uint8(float64(c.A) * opacity)
+			img.SetNRGBA(px, py, c)
+		}
+	}
+	return img
+}
+
+// ramp returns the ramp to use, defaulting to DefaultRamp.
+func (o RenderOptions) ramp() Ramp {
+	if len(o.Ramp) == 0 {
+		return DefaultRamp
+	}
+	return o.Ramp
+}
@@ -0,0 +1,134 @@
+package heatmap
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/darrenparkinson/dnas"
+)
+
+var testFloor = dnas.MapItem{ID: "f1", Details: dnas.MapItemDetails{Width: 100, Length: 50, OffsetX: 10, OffsetY: 5}}
+
+func TestNew(t *testing.T) {
+	g, err := New(testFloor, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.FloorID != "f1" || g.Cols != 4 || g.Rows != 2 || len(g.Cells) != 2 || len(g.Cells[0]) != 4 {
+		t.Errorf("grid %+v, want 4x2 cells", g)
+	}
+
+	tests := []struct {
+		details  dnas.MapItemDetails
+		cellSize float64
+	}{
+		{dnas.MapItemDetails{Width: 0, Length: 50}, 1},
+		{dnas.MapItemDetails{Width: math.Inf(1), Length: 50}, 1},
+		{dnas.MapItemDetails{Width: 100, Length: math.NaN()}, 1},
+		{testFloor.Details, 0},
+		{testFloor.Details, math.NaN()},
+		{testFloor.Details, 0.01},
+		{dnas.MapItemDetails{Width: 1e9, Length: 1e9}, 1},
+	}
+	for _, tt := range tests {
+		if _, err := NewFromDetails(tt.details, tt.cellSize); err == nil {
+			t.Errorf("NewFromDetails(%+v, %v) expected error", tt.details, tt.cellSize)
+		}
+	}
+	if _, err := NewFromDetails(dnas.MapItemDetails{Width: 2048, Length: 2048}, 1); err != nil {
+		t.Errorf("grid of MaxCells: %v", err)
+	}
+}
+
+func TestAdd(t *testing.T) {
+	g, _ := New(testFloor, 30)
+	points := []struct {
+		x, y, weight float64
+		ok           bool
+	}{
+		{10, 5, 1, true},
+		{40.1, 34.9, 2, true},
+		{110, 55, 1, true},
+		{9, 5, 1, false},
+		{111, 5, 1, false},
+		{math.NaN(), 5, 1, false},
+		{10, math.Inf(1), 1, false},
+		{10, 5, math.Inf(1), false},
+	}
+	for _, p := range points {
+		if ok := g.Add(p.x, p.y, p.weight); ok != p.ok {
+			t.Errorf("Add(%v, %v, %v) = %v, want %v", p.x, p.y, p.weight, ok, p.ok)
+		}
+	}
+	if g.Cells[0][0] != 1 || g.Cells[0][1] != 2 || g.Cells[1][3] != 1 || g.Max != 2 || g.Dropped != 5 {
+		t.Errorf("cells %v with max %v and %d dropped", g.Cells, g.Max, g.Dropped)
+	}
+}
+
+func TestAddRecords(t *testing.T) {
+	g, _ := New(testFloor, 30)
+	g.AddLocationDevices([]dnas.LocationDevice{
+		{FloorID: "f1", Coordinates: []float64{20, 10}},
+		{FloorID: "f2", Coordinates: []float64{20, 10}},
+		{FloorID: "f1"},
+	})
+	g.AddHistoryItems([]dnas.HistoryItem{
+		{FloorID: "f1", CoordinateX: "20", CoordinateY: "10"},
+		{FloorID: "f1", CoordinateX: "", CoordinateY: "10"},
+		{FloorID: "f1", CoordinateX: "50", CoordinateY: "10"},
+		{FloorID: "f2", CoordinateX: "x", CoordinateY: "y"},
+	})
+	// The bad history record in the middle of the batch is dropped without losing the records after it.
+	if g.Cells[0][0] != 2 || g.Cells[0][1] != 1 || g.Dropped != 2 {
+		t.Errorf("cells %v with %d dropped, want 2 and 1 with 2 dropped", g.Cells, g.Dropped)
+	}
+}
+
+func TestRender(t *testing.T) {
+	g, _ := NewFromDetails(dnas.MapItemDetails{Width: 100, Length: 100, OffsetX: 10, OffsetY: 5}, 50)
+	g.Add(10, 5, 1)
+	g.Add(60, 5, 4)
+
+	img := g.Image(RenderOptions{CellPixels: 4})
+	if img.Bounds() != image.Rect(0, 0, 8, 8) {
+		t.Fatalf("bounds %v, want 8x8", img.Bounds())
+	}
+	if c := img.NRGBAAt(0, 0); c != DefaultRamp.At(0.25) {
+		t.Errorf("low cell = %v, want %v", c, DefaultRamp.At(0.25))
+	}
+	if c := img.NRGBAAt(7, 0); c != DefaultRamp[len(DefaultRamp)-1] {
+		t.Errorf("high cell = %v, want the top of the ramp", c)
+	}
+	if c := img.NRGBAAt(0, 7); c.A != 0 {
+		t.Errorf("empty cell = %v, want transparent", c)
+	}
+
+	floor := image.NewRGBA(image.Rect(0, 0, 20, 10))
+	overlay := g.Overlay(floor, RenderOptions{Ramp: Ramp{{R: 255, A: 255}}, Opacity: 1})
+	if overlay.Bounds() != floor.Bounds() {
+		t.Errorf("overlay bounds %v, want the floor image size", overlay.Bounds())
+	}
+	if c := overlay.RGBAAt(19, 0); c != (color.RGBA{R: 255, A: 255}) {
+		t.Errorf("overlay pixel = %v", c)
+	}
+
+	var buf bytes.Buffer
+	if err := g.WritePNG(&buf, nil, RenderOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := g.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var decoded Grid
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Cols != 2 || decoded.Max != 4 || decoded.Cells[0][1] != 4 {
+		t.Errorf("decoded %+v", decoded)
+	}
+}
@@ -0,0 +1,34 @@
+package heatmap
+
+import "image/color"
+
+// Ramp is a colour ramp.  Colours are evenly spaced and interpolated linearly between.
+type Ramp []color.NRGBA
+
+// DefaultRamp runs from blue through cyan, green and yellow to red.
+var DefaultRamp = Ramp{
+	{R: 0, G: 0, B: 255, A: 160},
+	{R: 0, G: 255, B: 255, A: 190},
+	{R: 0, G: 255, B: 0, A: 210},
+	{R: 255, G: 255, B: 0, A: 230},
+	{R: 255, G: 0, B: 0, A: 255},
+}
+
+// At returns the colour for t, where t runs from 0 to 1.
+func (r Ramp) At(t float64) color.NRGBA {
+	if len(r) == 0 {
+		return color.NRGBA{}
+	}
+	if t <= 0 || len(r) == 1 {
+		return r[0]
+	}
+	if t >= 1 {
+		return r[len(r)-1]
+	}
+	pos := t * float64(len(r)-1)
+	i := int(pos)
+	f := pos - float64(i)
+	a, b := r[i], r[i+1]
+	lerp := func(x, y uint8) uint8 { return uint8(float64(x) + (float64(y)-float64(x))*f + 0.5) }
+	return color.NRGBA{R: lerp(a.R, b.R), G: lerp(a.G, b.G), B: lerp(a.B, b.B), A: lerp(a.A, b.A)}
+}
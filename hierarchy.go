@@ -0,0 +1,325 @@
+package dnas
+
+import "strings"
+
+// WalkFunc is called by Hierarchy.Walk for each node.  Returning ErrSkipChildren skips the children of the node,
+// while returning any other error stops the walk and returns that error.
+type WalkFunc func(n *HierarchyNode) error
+
+// Hierarchy is a navigable view of the map hierarchy returned by GetHierarchy.
+// Use NewHierarchy to create one.
+type Hierarchy struct {
+	roots        []*HierarchyNode
+	byID         map[string]*HierarchyNode
+	byImportedID map[string]*HierarchyNode
+	byName       map[string][]*HierarchyNode
+}
+
+// HierarchyNode is a single campus, building or floor in a Hierarchy.
+type HierarchyNode struct {
+	MapItem
+
+	parent   *HierarchyNode
+	children []*HierarchyNode
+}
+
+// Campus is a HierarchyNode at the campus level.
+type Campus struct{ *HierarchyNode }
+
+// Building is a HierarchyNode at the building level.
+type Building struct{ *HierarchyNode }
+
+// Floor is a HierarchyNode at the floor level.
+type Floor struct{ *HierarchyNode }
+
+// NewHierarchy builds a Hierarchy from the response of GetHierarchy.
+// Items are linked to the item they are nested within.  Top level items that refer to a parent elsewhere in the response
+// are linked to it, while items whose parent is not in the response are treated as roots.
+func NewHierarchy(resp MapHierarchyResponse) *Hierarchy {
+	h := &Hierarchy{
+		byID:         make(map[string]*HierarchyNode),
+		byImportedID: make(map[string]*HierarchyNode),
+		byName:       make(map[string][]*HierarchyNode),
+	}
+	var top []*HierarchyNode
+	for _, item := range resp.Map {
+		if n := h.add(item, nil); n != nil {
+			top = append(top, n)
+		}
+	}
+	for _, n := range top {
+		if p, ok := h.byID[parentID(n.MapItem)]; ok && p != n && !p.isDescendantOf(n) {
+			n.parent = p
+			p.children = append(p.children, n)
+			continue
+		}
+		h.roots = append(h.roots, n)
+	}
+	return h
+}
+
+// add indexes the item and its children, returning the new node or nil if the identifier has already been seen.
+func (h *Hierarchy) add(item MapItem, parent *HierarchyNode) *HierarchyNode {
+	if _, ok := h.byID[item.ID]; ok && item.ID != "" {
+		return nil
+	}
+	n := &HierarchyNode{MapItem: item, parent: parent}
+	if item.ID != "" {
+		h.byID[item.ID] = n
+	}
+	if item.ImportedID != "" {
+		if _, ok := h.byImportedID[item.ImportedID]; !ok {
+			h.byImportedID[item.ImportedID] = n
+		}
+	}
+	key := strings.ToLower(item.Name)
+	h.byName[key] = append(h.byName[key], n)
+	for _, child := range item.RelationshipData.Children {
+		if c := h.add(child, n); c != nil {
+			n.children = append(n.children, c)
+		}
+	}
+	return n
+}
+
+// parentID returns the identifier of the parent of the item, from either Parent or the last of AncestorIds.
+// Parent may be provided as an identifier or as an object containing one.
+func parentID(item MapItem) string {
+	switch p := item.RelationshipData.Parent.(type) {
+	case string:
+		if p != "" {
+			return p
+		}
+	case map[string]interface{}:
+		if id, ok := p["id"].(string); ok && id != "" {
+			return id
+		}
+	}
+	if ids := item.RelationshipData.AncestorIds; len(ids) > 0 {
+		return ids[len(ids)-1]
+	}
+	return ""
+}
+
+// Roots returns the top level nodes, usually campuses.
+func (h *Hierarchy) Roots() []*HierarchyNode {
+	return h.roots
+}
+
+// ByID returns the node with the given identifier.
+func (h *Hierarchy) ByID(id string) (*HierarchyNode, bool) {
+	n, ok := h.byID[id]
+	return n, ok
+}
+
+// ByImportedID returns the node with the given imported identifier.
+func (h *Hierarchy) ByImportedID(id string) (*HierarchyNode, bool) {
+	n, ok := h.byImportedID[id]
+	return n, ok
+}
+
+// FindByName returns all nodes with the given name, ignoring case.
+func (h *Hierarchy) FindByName(name string) []*HierarchyNode {
+	return h.byName[strings.ToLower(name)]
+}
+
+// FindByPath returns the node at the given path of names separated by "/", e.g. "Campus/Building/Floor", ignoring case.
+// The path starts at a root node.
+func (h *Hierarchy) FindByPath(path string) (*HierarchyNode, bool) {
+	names := strings.Split(strings.Trim(path, "/"), "/")
+	nodes := h.roots
+	var found *HierarchyNode
+	for _, name := range names {
+		found = nil
+		for _, n := range nodes {
+			if strings.EqualFold(n.Name, name) {
+				found = n
+				break
+			}
+		}
+		if found == nil {
+			return nil, false
+		}
+		nodes = found.children
+	}
+	return found, found != nil
+}
+
+// Walk calls fn for every node in the hierarchy, depth first, parents before their children.
+func (h *Hierarchy) Walk(fn WalkFunc) error {
+	for _, n := range h.roots {
+		if err := n.walk(fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Campuses returns every campus in the hierarchy.
+func (h *Hierarchy) Campuses() []Campus {
+	var campuses []Campus
+	h.Walk(func(n *HierarchyNode) error {
+		if n.IsCampus() {
+			campuses = append(campuses, Campus{n})
+		}
+		return nil
+	})
+	return campuses
+}
+
+// Buildings returns every building in the hierarchy.
+func (h *Hierarchy) Buildings() []Building {
+	var buildings []Building
+	h.Walk(func(n *HierarchyNode) error {
+		if n.IsBuilding() {
+			buildings = append(buildings, Building{n})
+		}
+		return nil
+	})
+	return buildings
+}
+
+// Floors returns every floor in the hierarchy.
+func (h *Hierarchy) Floors() []Floor {
+	var floors []Floor
+	h.Walk(func(n *HierarchyNode) error {
+		if n.IsFloor() {
+			floors = append(floors, Floor{n})
+			return ErrSkipChildren
+		}
+		return nil
+	})
+	return floors
+}
+
+// walk calls fn for the node and its descendants.
+func (n *HierarchyNode) walk(fn WalkFunc) error {
+	if err := fn(n); err != nil {
+		if err == ErrSkipChildren {
+			return nil
+		}
+		return err
+	}
+	for _, c := range n.children {
+		if err := c.walk(fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isDescendantOf reports whether n is below other in the hierarchy.
+func (n *HierarchyNode) isDescendantOf(other *HierarchyNode) bool {
+	for p := n.parent; p != nil; p = p.parent {
+		if p == other {
+			return true
+		}
+	}
+	return false
+}
+
+// Parent returns the parent of the node, or nil for a root node.
+func (n *HierarchyNode) Parent() *HierarchyNode {
+	return n.parent
+}
+
+// Children returns the children of the node.
+func (n *HierarchyNode) Children() []*HierarchyNode {
+	return n.children
+}
+
+// Ancestors returns the ancestors of the node, starting with the root.
+func (n *HierarchyNode) Ancestors() []*HierarchyNode {
+	var ancestors []*HierarchyNode
+	for p := n.parent; p != nil; p = p.parent {
+		ancestors = append([]*HierarchyNode{p}, ancestors...)
+	}
+	return ancestors
+}
+
+// Path returns the names from the root to the node separated by "/", e.g. "Campus/Building/Floor".
+func (n *HierarchyNode) Path() string {
+	var names []string
+	for _, a := range n.Ancestors() {
+		names = append(names, a.Name)
+	}
+	return strings.Join(append(names, n.Name), "/")
+}
+
+// IsCampus reports whether the node is a campus.
+func (n *HierarchyNode) IsCampus() bool { return strings.EqualFold(n.Level, MapLevelCampus) }
+
+// IsBuilding reports whether the node is a building.
+func (n *HierarchyNode) IsBuilding() bool { return strings.EqualFold(n.Level, MapLevelBuilding) }
+
+// IsFloor reports whether the node is a floor.
+func (n *HierarchyNode) IsFloor() bool { return strings.EqualFold(n.Level, MapLevelFloor) }
+
+// Campus returns the campus the node is in, which may be the node itself.
+func (n *HierarchyNode) Campus() (Campus, bool) {
+	for p := n; p != nil; p = p.parent {
+		if p.IsCampus() {
+			return Campus{p}, true
+		}
+	}
+	return Campus{}, false
+}
+
+// Building returns the building the node is in, which may be the node itself.
+func (n *HierarchyNode) Building() (Building, bool) {
+	for p := n; p != nil; p = p.parent {
+		if p.IsBuilding() {
+			return Building{p}, true
+		}
+	}
+	return Building{}, false
+}
+
+// Floor returns the node as a Floor if it is one.
+func (n *HierarchyNode) Floor() (Floor, bool) {
+	if n.IsFloor() {
+		return Floor{n}, true
+	}
+	return Floor{}, false
+}
+
+// Buildings returns the buildings in the campus.
+func (c Campus) Buildings() []Building {
+	var buildings []Building
+	c.walk(func(n *HierarchyNode) error {
+		if n.IsBuilding() {
+			buildings = append(buildings, Building{n})
+			return ErrSkipChildren
+		}
+		return nil
+	})
+	return buildings
+}
+
+// Floors returns the floors in the campus.
+func (c Campus) Floors() []Floor {
+	return floorsBelow(c.HierarchyNode)
+}
+
+// Floors returns the floors in the building.
+func (b Building) Floors() []Floor {
+	return floorsBelow(b.HierarchyNode)
+}
+
+// Number returns the floor number.
+func (f Floor) Number() int64 {
+	return f.Details.FloorNumber
+}
+
+// floorsBelow returns the floors at or below the node.
+func floorsBelow(n *HierarchyNode) []Floor {
+	var floors []Floor
+	n.walk(func(n *HierarchyNode) error {
+		if n.IsFloor() {
+			floors = append(floors, Floor{n})
+			return ErrSkipChildren
+		}
+		return nil
+	})
+	return floors
+}
@@ -0,0 +1,206 @@
+package dnas
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// HierarchyChangeType represents the type of change found by DiffHierarchy
+type HierarchyChangeType string
+
+// Fields for HierarchyChangeType
+const (
+	ElementAdded     HierarchyChangeType = "ADDED"
+	ElementRemoved   HierarchyChangeType = "REMOVED"
+	ElementRenamed   HierarchyChangeType = "RENAMED"
+	ElementIDChanged HierarchyChangeType = "ID_CHANGED"
+	ElementResized   HierarchyChangeType = "DIMENSIONS_CHANGED"
+	ElementNewImage  HierarchyChangeType = "IMAGE_CHANGED"
+	ElementMoved     HierarchyChangeType = "PARENT_CHANGED"
+)
+
+// HierarchyChange is a single difference between two map hierarchy snapshots.
+type HierarchyChange struct {
+	Type  HierarchyChangeType `json:"type"`
+	Level string              `json:"level"`
+
+	// ID of the element in the new snapshot, or in the old snapshot if it was removed.
+	ID string `json:"id"`
+
+	// Path of the element in the new snapshot, or in the old snapshot if it was removed.
+	Path string `json:"path"`
+
+	// Old and New describe the value that changed, e.g. the old and new name for ElementRenamed.
+	Old string `json:"old,omitempty"`
+	New string `json:"new,omitempty"`
+}
+
+// HierarchyDiff contains the differences between two map hierarchy snapshots.
+type HierarchyDiff struct {
+	Changes []HierarchyChange `json:"changes"`
+}
+
+// SaveHierarchySnapshot writes the map hierarchy as JSON so that it can be compared later.
+func SaveHierarchySnapshot(w io.Writer, h MapHierarchyResponse) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(h)
+}
+
+// LoadHierarchySnapshot reads a map hierarchy written by SaveHierarchySnapshot, or saved directly from the API.
+func LoadHierarchySnapshot(r io.Reader) (MapHierarchyResponse, error) {
+	var h MapHierarchyResponse
+	err := json.NewDecoder(r).Decode(&h)
+	return h, err
+}
+
+// DiffHierarchy compares two map hierarchy snapshots, reporting the changes from before to after.
+// Elements are matched by ID, then by ImportedID, then by path, and finally by level and name where that is unique,
+// so that re-imported maps with new identifiers are reported as changed rather than removed and added.
+func DiffHierarchy(before, after MapHierarchyResponse) HierarchyDiff {
+	oh, nh := NewHierarchy(before), NewHierarchy(after)
+	oldNodes, newNodes := allNodes(oh), allNodes(nh)
+
+	matched := make(map[*HierarchyNode]*HierarchyNode) // old to new
+	used := make(map[*HierarchyNode]bool)              // new nodes already matched
+	match := func(key func(*HierarchyNode) string, unique bool) {
+		index := make(map[string][]*HierarchyNode)
+		for _, n := range newNodes {
+			if k := key(n); k != "" && !used[n] {
+				index[k] = append(index[k], n)
+			}
+		}
+		counts := make(map[string]int)
+		for _, o := range oldNodes {
+			if _, ok := matched[o]; !ok {
+				counts[key(o)]++
+			}
+		}
+		for _, o := range oldNodes {
+			if _, ok := matched[o]; ok {
+				continue
+			}
+			k := key(o)
+			candidates := index[k]
+			if k == "" || len(candidates) == 0 || (unique && (len(candidates) != 1 || counts[k] != 1)) {
+				continue
+			}
+			for i, n := range candidates {
+				if !used[n] {
+					matched[o] = n
+					used[n] = true
+					index[k] = candidates[i+1:]
+					break
+				}
+			}
+		}
+	}
+	match(func(n *HierarchyNode) string { return n.ID }, false)
+	match(func(n *HierarchyNode) string { return n.ImportedID }, false)
+	match(func(n *HierarchyNode) string { return strings.ToLower(n.Level + ":" + n.Path()) }, false)
+	match(func(n *HierarchyNode) string { return strings.ToLower(n.Level + ":" + n.Name) }, true)
+
+	var diff HierarchyDiff
+	add := func(typ HierarchyChangeType, n *HierarchyNode, from, to string) {
+		diff.Changes = append(diff.Changes, HierarchyChange{Type: typ, Level: n.Level, ID: n.ID, Path: n.Path(), Old: from, New: to})
+	}
+	for _, o := range oldNodes {
+		n, ok := matched[o]
+		if !ok {
+			add(ElementRemoved, o, "", "")
+			continue
+		}
+		if o.ID != n.ID {
+			add(ElementIDChanged, n, o.ID, n.ID)
+		}
+		if o.Name != n.Name {
+			add(ElementRenamed, n, o.Name, n.Name)
+		}
+		if od, nd := dimensions(o.Details), dimensions(n.Details); od != nd {
+			add(ElementResized, n, od, nd)
+		}
+		if o.Details.Image.Cksum != n.Details.Image.Cksum {
+			add(ElementNewImage, n, o.Details.Image.Cksum, n.Details.Image.Cksum)
+		}
+		op, np := o.Parent(), n.Parent()
+		if (op == nil) != (np == nil) || (op != nil && matched[op] != np) {
+			add(ElementMoved, n, pathOf(op), pathOf(np))
+		}
+	}
+	for _, n := range newNodes {
+		if !used[n] {
+			add(ElementAdded, n, "", "")
+		}
+	}
+	sort.SliceStable(diff.Changes, func(i, j int) bool {
+		if diff.Changes[i].Path != diff.Changes[j].Path {
+			return diff.Changes[i].Path < diff.Changes[j].Path
+		}
+		return diff.Changes[i].Type < diff.Changes[j].Type
+	})
+	return diff
+}
+
+// Empty reports whether there are no differences.
+func (d HierarchyDiff) Empty() bool {
+	return len(d.Changes) == 0
+}
+
+// WriteJSON writes the differences as JSON.
+func (d HierarchyDiff) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(d)
+}
+
+// String returns the differences as human readable text with one change per line.
+func (d HierarchyDiff) String() string {
+	var b strings.Builder
+	for _, c := range d.Changes {
+		switch c.Type {
+		case ElementAdded:
+			fmt.Fprintf(&b, "added %s %q (%s)\n", c.Level, c.Path, c.ID)
+		case ElementRemoved:
+			fmt.Fprintf(&b, "removed %s %q (%s)\n", c.Level, c.Path, c.ID)
+		case ElementRenamed:
+			fmt.Fprintf(&b, "renamed %s %q: %q -> %q\n", c.Level, c.Path, c.Old, c.New)
+		case ElementIDChanged:
+			fmt.Fprintf(&b, "id changed for %s %q: %s -> %s\n", c.Level, c.Path, c.Old, c.New)
+		case ElementResized:
+			fmt.Fprintf(&b, "dimensions changed for %s %q: %s -> %s\n", c.Level, c.Path, c.Old, c.New)
+		case ElementNewImage:
+			fmt.Fprintf(&b, "image changed for %s %q: %s -> %s\n", c.Level, c.Path, c.Old, c.New)
+		case ElementMoved:
+			fmt.Fprintf(&b, "moved %s %q: from %q to %q\n", c.Level, c.Path, c.Old, c.New)
+		default:
+			fmt.Fprintf(&b, "%s %s %q: %s -> %s\n", c.Type, c.Level, c.Path, c.Old, c.New)
+		}
+	}
+	return b.String()
+}
+
+// allNodes returns every node in the hierarchy in walk order.
+func allNodes(h *Hierarchy) []*HierarchyNode {
+	var nodes []*HierarchyNode
+	h.Walk(func(n *HierarchyNode) error {
+		nodes = append(nodes, n)
+		return nil
+	})
+	return nodes
+}
+
+// dimensions describes the size and position of a map element for comparison.
+func dimensions(d MapItemDetails) string {
+	return fmt.Sprintf("width %g length %g height %g offset %g,%g", d.Width, d.Length, d.Height, d.OffsetX, d.OffsetY)
+}
+
+// pathOf returns the path of the node, or an empty string for nil.
+func pathOf(n *HierarchyNode) string {
+	if n == nil {
+		return ""
+	}
+	return n.Path()
+}
@@ -0,0 +1,336 @@
+package dnas
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultHierarchyTTL is the time a HierarchyResolver keeps the map hierarchy before refreshing it when none is given.
+const DefaultHierarchyTTL = 15 * time.Minute
+
+// DefaultHierarchyRetry is the time a HierarchyResolver waits after failing to load the hierarchy before trying again
+// when none is given.
+const DefaultHierarchyRetry = 30 * time.Second
+
+// DefaultHierarchyLoadTimeout is the time a HierarchyResolver allows for loading the hierarchy when none is given.
+const DefaultHierarchyLoadTimeout = time.Minute
+
+// ResolvedLocation contains the human readable location of a device.
+type ResolvedLocation struct {
+	CampusID     string `json:"campusId,omitempty"`
+	CampusName   string `json:"campusName,omitempty"`
+	BuildingID   string `json:"buildingId,omitempty"`
+	BuildingName string `json:"buildingName,omitempty"`
+	FloorID      string `json:"floorId,omitempty"`
+	FloorName    string `json:"floorName,omitempty"`
+	FloorNumber  int64  `json:"floorNumber,omitempty"`
+
+	// Unresolved contains any identifiers that could not be found in the map hierarchy, which usually indicates a stale map.
+	Unresolved []string `json:"unresolved,omitempty"`
+}
+
+// EnrichedDevice is a LocationDevice with its resolved location.
+type EnrichedDevice struct {
+	LocationDevice
+	Location ResolvedLocation `json:"location"`
+}
+
+// EnrichedHistoryItem is a HistoryItem with its resolved location.
+type EnrichedHistoryItem struct {
+	HistoryItem
+	Location ResolvedLocation `json:"location"`
+}
+
+// HierarchyResolver resolves campus, building and floor identifiers to names using a cached copy of the map hierarchy.
+// The hierarchy is loaded on first use and refreshed once it is older than the TTL.  It is safe for concurrent use.
+//
+// Identifiers that can't be found in the hierarchy, usually because it is stale, are filled from the hierarchy path
+// of the device or history item, such as LocationDevice.Hierarchy, where it is given.
+type HierarchyResolver struct {
+	// TTL is how long the hierarchy is kept before it is refreshed.  If zero, DefaultHierarchyTTL is used.
+	TTL time.Duration
+
+	// RetryInterval is how long to wait after a failed load before trying again.  Until then the previous
+	// hierarchy is used, or if there is none the error is returned.  If zero, DefaultHierarchyRetry is used.
+	RetryInterval time.Duration
+
+	// LoadTimeout limits the time taken to load the hierarchy.  Loads are shared by every caller, so they don't use
+	// the caller's context.  If zero, DefaultHierarchyLoadTimeout is used.
+	LoadTimeout time.Duration
+
+	load func(ctx context.Context) (MapHierarchyResponse, error)
+
+	mu        sync.Mutex
+	hierarchy *Hierarchy
+	loadedAt  time.Time
+	retryAt   time.Time
+	lastErr   error
+
+	// loading is closed when the load in progress, if any, completes.
+	loading chan struct{}
+}
+
+// NewHierarchyResolver returns a HierarchyResolver that loads the hierarchy using the given MapService.
+func NewHierarchyResolver(s *MapService, ttl time.Duration) *HierarchyResolver {
+	return &HierarchyResolver{TTL: ttl, load: s.GetHierarchy}
+}
+
+// Hierarchy returns the cached hierarchy, loading or refreshing it if required.
+// If a refresh fails, the previous hierarchy continues to be used and the error is available from LastError.
+// An error is only returned if no hierarchy has been loaded.  While a refresh is in progress the previous
+// hierarchy is returned without waiting for it, and after a failure no refresh is tried until RetryInterval has passed.
+// If the context is done while waiting for the first load, the context's error is returned but the load continues.
+func (r *HierarchyResolver) Hierarchy(ctx context.Context) (*Hierarchy, error) {
+	ttl := r.TTL
+	if ttl <= 0 {
+		ttl = DefaultHierarchyTTL
+	}
+	for {
+		r.mu.Lock()
+		h := r.hierarchy
+		if h != nil && time.Since(r.loadedAt) < ttl {
+			r.mu.Unlock()
+			return h, nil
+		}
+		if r.loading == nil && time.Now().Before(r.retryAt) {
+			err := r.lastErr
+			r.mu.Unlock()
+			if h != nil {
+				return h, nil
+			}
+			return nil, err
+		}
+		loading := r.loading
+		if loading == nil {
+			loading = r.startLoad()
+		}
+		r.mu.Unlock()
+		if h != nil {
+			return h, nil
+		}
+		select {
+		case <-loading:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Refresh loads the hierarchy immediately, regardless of the TTL.
+func (r *HierarchyResolver) Refresh(ctx context.Context) error {
+	return r.refresh(ctx)
+}
+
+// LastError returns the error from the most recent attempt to load the hierarchy, or nil if it succeeded.
+func (r *HierarchyResolver) LastError() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lastErr
+}
+
+// refresh starts a load, or joins the load already in progress, and waits for it to complete.
+// The previous hierarchy can be used meanwhile.
+func (r *HierarchyResolver) refresh(ctx context.Context) error {
+	r.mu.Lock()
+	loading := r.loading
+	if loading == nil {
+		loading = r.startLoad()
+	}
+	r.mu.Unlock()
+	select {
+	case <-loading:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lastErr
+}
+
+// startLoad starts loading the hierarchy in the background and returns a channel closed when it completes.
+// The caller must hold r.mu.
+func (r *HierarchyResolver) startLoad() chan struct{} {
+	r.loading = make(chan struct{})
+	go r.finishLoad(r.loading)
+	return r.loading
+}
+
+// finishLoad loads the hierarchy and records the result, waking anyone waiting for the load.  The load uses its own
+// context, so that a caller giving up doesn't fail the load for everyone else.
+func (r *HierarchyResolver) finishLoad(loading chan struct{}) {
+	timeout := r.LoadTimeout
+	if timeout <= 0 {
+		timeout = DefaultHierarchyLoadTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	resp, err := r.load(ctx)
+	cancel()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastErr = err
+	if err != nil {
+		retry := r.RetryInterval
+		if retry <= 0 {
+			retry = DefaultHierarchyRetry
+		}
+		r.retryAt = time.Now().Add(retry)
+	} else {
+		r.hierarchy = NewHierarchy(resp)
+		r.loadedAt = time.Now()
+		r.retryAt = time.Time{}
+	}
+	r.loading = nil
+	close(loading)
+}
+
+// Resolve returns the names of the given campus, building and floor.  Any of the identifiers may be empty,
+// in which case they are filled from the ancestors of the floor or building where possible.
+func (r *HierarchyResolver) Resolve(ctx context.Context, campusID, buildingID, floorID string) (ResolvedLocation, error) {
+	return r.resolve(ctx, campusID, buildingID, floorID, "")
+}
+
+// resolve is Resolve with the hierarchy path of the device as a fallback.
+func (r *HierarchyResolver) resolve(ctx context.Context, campusID, buildingID, floorID, path string) (ResolvedLocation, error) {
+	h, err := r.Hierarchy(ctx)
+	return resolveLocation(h, campusID, buildingID, floorID, path), err
+}
+
+// EnrichDevice returns the device with its resolved location.
+func (r *HierarchyResolver) EnrichDevice(ctx context.Context, d LocationDevice) (EnrichedDevice, error) {
+	loc, err := r.resolve(ctx, d.CampusID, d.BuildingID, d.FloorID, d.Hierarchy)
+	return EnrichedDevice{LocationDevice: d, Location: loc}, err
+}
+
+// EnrichDevices returns the devices with their resolved locations.  If the hierarchy can't be loaded, the names are
+// taken from each device's hierarchy path and the error is returned with them.
+func (r *HierarchyResolver) EnrichDevices(ctx context.Context, devices []LocationDevice) ([]EnrichedDevice, error) {
+	h, err := r.Hierarchy(ctx)
+	enriched := make([]EnrichedDevice, len(devices))
+	for i, d := range devices {
+		enriched[i] = EnrichedDevice{LocationDevice: d, Location: resolveLocation(h, d.CampusID, d.BuildingID, d.FloorID, d.Hierarchy)}
+	}
+	return enriched, err
+}
+
+// EnrichHistoryItem returns the history item with its resolved location.
+func (r *HierarchyResolver) EnrichHistoryItem(ctx context.Context, item HistoryItem) (EnrichedHistoryItem, error) {
+	loc, err := r.resolve(ctx, item.CampusID, item.BuildingID, item.FloorID, item.FloorHierarchy)
+	return EnrichedHistoryItem{HistoryItem: item, Location: loc}, err
+}
+
+// EnrichHistoryItems returns the history items with their resolved locations.  If the hierarchy can't be loaded, the
+// names are taken from each item's hierarchy path and the error is returned with them.
+func (r *HierarchyResolver) EnrichHistoryItems(ctx context.Context, items []HistoryItem) ([]EnrichedHistoryItem, error) {
+	h, err := r.Hierarchy(ctx)
+	enriched := make([]EnrichedHistoryItem, len(items))
+	for i, item := range items {
+		enriched[i] = EnrichedHistoryItem{HistoryItem: item, Location: resolveLocation(h, item.CampusID, item.BuildingID, item.FloorID, item.FloorHierarchy)}
+	}
+	return enriched, err
+}
+
+// resolveLocation looks up the identifiers in the hierarchy, using the hierarchy path for any names not found.
+// If h is nil, the names are only taken from the path.
+func resolveLocation(h *Hierarchy, campusID, buildingID, floorID, path string) ResolvedLocation {
+	loc := ResolvedLocation{CampusID: campusID, BuildingID: buildingID, FloorID: floorID}
+	if h == nil {
+		resolvePath(&loc, nil, path)
+		return loc
+	}
+	var floor, building, campus *HierarchyNode
+	lookup := func(id string) *HierarchyNode {
+		if id == "" {
+			return nil
+		}
+		n, ok := h.ByID(id)
+		if !ok {
+			loc.Unresolved = append(loc.Unresolved, id)
+			return nil
+		}
+		return n
+	}
+	floor = lookup(floorID)
+	building = lookup(buildingID)
+	campus = lookup(campusID)
+
+	if floor != nil {
+		loc.FloorName = floor.Name
+		loc.FloorNumber = floor.Details.FloorNumber
+		if building == nil && buildingID == "" {
+			if b, ok := floor.Building(); ok {
+				building = b.HierarchyNode
+				loc.BuildingID = b.ID
+			}
+		}
+	}
+	if building != nil {
+		loc.BuildingName = building.Name
+		if campus == nil && campusID == "" {
+			if c, ok := building.Campus(); ok {
+				campus = c.HierarchyNode
+				loc.CampusID = c.ID
+			}
+		}
+	}
+	if campus == nil && campusID == "" && floor != nil {
+		if c, ok := floor.Campus(); ok {
+			campus = c.HierarchyNode
+			loc.CampusID = c.ID
+		}
+	}
+	if campus != nil {
+		loc.CampusName = campus.Name
+	}
+	resolvePath(&loc, h, path)
+	return loc
+}
+
+// resolvePath fills any missing names from a hierarchy path of names, e.g. "Campus>Building>Floor", as given
+// by LocationDevice.Hierarchy and HistoryItem.FloorHierarchy.  Where the path is found in the hierarchy its nodes are
+// used, and otherwise the names are taken as the campus, building and floor in turn, using the last three of longer paths.
+func resolvePath(loc *ResolvedLocation, h *Hierarchy, path string) {
+	if path == "" || loc.CampusName != "" && loc.BuildingName != "" && loc.FloorName != "" {
+		return
+	}
+	names := strings.FieldsFunc(path, func(r rune) bool { return r == '>' || r == '/' })
+	for i := range names {
+		names[i] = strings.TrimSpace(names[i])
+	}
+	if len(names) == 0 {
+		return
+	}
+	if h != nil {
+		if n, ok := h.FindByPath(strings.Join(names, "/")); ok {
+			if f, ok := n.Floor(); ok && loc.FloorName == "" {
+				loc.FloorName, loc.FloorNumber = f.Name, f.Details.FloorNumber
+				if loc.FloorID == "" {
+					loc.FloorID = f.ID
+				}
+			}
+			if b, ok := n.Building(); ok && loc.BuildingName == "" {
+				loc.BuildingName = b.Name
+				if loc.BuildingID == "" {
+					loc.BuildingID = b.ID
+				}
+			}
+			if c, ok := n.Campus(); ok && loc.CampusName == "" {
+				loc.CampusName = c.Name
+				if loc.CampusID == "" {
+					loc.CampusID = c.ID
+				}
+			}
+			return
+		}
+	}
+	if len(names) > 3 {
+		names = names[len(names)-3:]
+	}
+	for i, level := range []*string{&loc.CampusName, &loc.BuildingName, &loc.FloorName}[:len(names)] {
+		if *level == "" {
+			*level = names[i]
+		}
+	}
+}
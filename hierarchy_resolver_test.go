@@ -0,0 +1,200 @@
+package dnas
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testHierarchy returns a campus with a building containing two floors.
+func testHierarchy() MapHierarchyResponse {
+	floor := func(id, name string, number int64) MapItem {
+		return MapItem{ID: id, Name: name, Level: MapLevelFloor, Details: MapItemDetails{FloorNumber: number, Width: 100, Length: 50}}
+	}
+	return MapHierarchyResponse{Map: []MapItem{{
+		ID: "c1", Name: "Campus", Level: MapLevelCampus,
+		RelationshipData: MapItemRelationshipData{Children: []MapItem{{
+			ID: "b1", Name: "Building", Level: MapLevelBuilding,
+			RelationshipData: MapItemRelationshipData{Children: []MapItem{
+				floor("f1", "Ground", 0),
+				floor("f2", "First", 1),
+			}},
+		}}},
+	}}}
+}
+
+// countingLoader loads testHierarchy, or returns err if set, counting the loads.
+type countingLoader struct {
+	loads int32
+	err   error
+	wait  chan struct{}
+}
+
+func (l *countingLoader) load(ctx context.Context) (MapHierarchyResponse, error) {
+	atomic.AddInt32(&l.loads, 1)
+	if l.wait != nil {
+		select {
+		case <-l.wait:
+		case <-ctx.Done():
+			return MapHierarchyResponse{}, ctx.Err()
+		}
+	}
+	if l.err != nil {
+		return MapHierarchyResponse{}, l.err
+	}
+	return testHierarchy(), nil
+}
+
+func (l *countingLoader) count() int {
+	return int(atomic.LoadInt32(&l.loads))
+}
+
+func TestResolverTTL(t *testing.T) {
+	l := &countingLoader{}
+	r := &HierarchyResolver{TTL: 50 * time.Millisecond, load: l.load}
+	ctx := context.Background()
+	first, err := r.Hierarchy(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h, _ := r.Hierarchy(ctx); h != first || l.count() != 1 {
+		t.Fatalf("loads = %d, want the cached hierarchy", l.count())
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	// The stale hierarchy is returned while it is refreshed.
+	if h, err := r.Hierarchy(ctx); h != first || err != nil {
+		t.Errorf("stale Hierarchy = %p, %v, want the previous hierarchy", h, err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		if h, _ := r.Hierarchy(ctx); h != first {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("hierarchy was not refreshed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if l.count() != 2 {
+		t.Errorf("loads = %d, want 2", l.count())
+	}
+}
+
+func TestResolverRetryInterval(t *testing.T) {
+	failed := errors.New("unavailable")
+	l := &countingLoader{err: failed}
+	r := &HierarchyResolver{RetryInterval: 50 * time.Millisecond, load: l.load}
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if _, err := r.Hierarchy(ctx); err != failed {
+			t.Fatalf("err = %v, want load error", err)
+		}
+	}
+	if l.count() != 1 {
+		t.Errorf("loads = %d, want 1 until the retry interval passes", l.count())
+	}
+	if r.LastError() != failed {
+		t.Errorf("LastError = %v, want load error", r.LastError())
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	l.err = nil
+	if _, err := r.Hierarchy(ctx); err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	if l.count() != 2 || r.LastError() != nil {
+		t.Errorf("loads = %d, LastError = %v, want a successful retry", l.count(), r.LastError())
+	}
+}
+
+func TestResolverSingleLoad(t *testing.T) {
+	l := &countingLoader{wait: make(chan struct{})}
+	r := &HierarchyResolver{load: l.load}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := r.Hierarchy(context.Background()); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(l.wait)
+	wg.Wait()
+	if l.count() != 1 {
+		t.Errorf("loads = %d, want 1 shared load", l.count())
+	}
+}
+
+func TestResolverCallerCancelled(t *testing.T) {
+	l := &countingLoader{wait: make(chan struct{})}
+	r := &HierarchyResolver{load: l.load}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := r.Hierarchy(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want the caller's context error", err)
+	}
+	close(l.wait)
+	if _, err := r.Hierarchy(context.Background()); err != nil {
+		t.Fatalf("second caller: %v", err)
+	}
+	if l.count() != 1 || r.LastError() != nil {
+		t.Errorf("loads = %d, LastError = %v, want the first load to complete", l.count(), r.LastError())
+	}
+}
+
+func TestResolverResolve(t *testing.T) {
+	l := &countingLoader{}
+	r := &HierarchyResolver{load: l.load}
+	loc, err := r.Resolve(context.Background(), "", "", "f2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ResolvedLocation{CampusID: "c1", CampusName: "Campus", BuildingID: "b1", BuildingName: "Building", FloorID: "f2", FloorName: "First", FloorNumber: 1}
+	if loc.CampusID != want.CampusID || loc.CampusName != want.CampusName || loc.BuildingName != want.BuildingName ||
+		loc.FloorName != want.FloorName || loc.FloorNumber != want.FloorNumber || len(loc.Unresolved) != 0 {
+		t.Errorf("location = %+v, want %+v", loc, want)
+	}
+
+	// Stale identifiers are reported, and the path found in the hierarchy is used instead.
+	d, err := r.EnrichDevice(context.Background(), LocationDevice{FloorID: "gone", Hierarchy: "Campus>Building>Ground"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Location.FloorName != "Ground" || d.Location.CampusID != "c1" || len(d.Location.Unresolved) != 1 {
+		t.Errorf("location = %+v, want Ground from the path with gone unresolved", d.Location)
+	}
+}
+
+func TestResolverPathFallback(t *testing.T) {
+	failed := errors.New("unavailable")
+	r := &HierarchyResolver{load: (&countingLoader{err: failed}).load}
+	devices := []LocationDevice{
+		{FloorID: "f1", Hierarchy: "Org>Campus>Building>Ground"},
+		{BuildingID: "b1", Hierarchy: "Campus>Building"},
+	}
+	enriched, err := r.EnrichDevices(context.Background(), devices)
+	if err != failed {
+		t.Errorf("err = %v, want load error", err)
+	}
+	if len(enriched) != 2 {
+		t.Fatalf("enriched %d devices, want 2", len(enriched))
+	}
+	if loc := enriched[0].Location; loc.CampusName != "Campus" || loc.BuildingName != "Building" || loc.FloorName != "Ground" {
+		t.Errorf("long path = %+v, want the last three names", loc)
+	}
+	if loc := enriched[1].Location; loc.CampusName != "Campus" || loc.BuildingName != "Building" || loc.FloorName != "" {
+		t.Errorf("short path = %+v, want campus and building only", loc)
+	}
+
+	items, err := r.EnrichHistoryItems(context.Background(), []HistoryItem{{FloorHierarchy: "Campus/Building/First"}})
+	if err != failed || len(items) != 1 || items[0].Location.FloorName != "First" {
+		t.Errorf("history items = %+v, %v, want names from the path with the load error", items, err)
+	}
+}
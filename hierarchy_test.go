@@ -0,0 +1,161 @@
+package dnas
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// nodeIDs returns the identifiers of the nodes.
+func nodeIDs(nodes []*HierarchyNode) string {
+	var ids []string
+	for _, n := range nodes {
+		ids = append(ids, n.ID)
+	}
+	return strings.Join(ids, " ")
+}
+
+func TestNewHierarchy(t *testing.T) {
+	resp := testHierarchy()
+	resp.Map = append(resp.Map,
+		// Top level items referring to a parent elsewhere in the response, by identifier, object or ancestors.
+		MapItem{ID: "f3", Name: "Second", Level: MapLevelFloor, RelationshipData: MapItemRelationshipData{Parent: "b1"}},
+		MapItem{ID: "b2", Name: "Annex", Level: MapLevelBuilding, ImportedID: "imp-b2",
+			RelationshipData: MapItemRelationshipData{Parent: map[string]interface{}{"id": "c1"}}},
+		MapItem{ID: "f4", Name: "Ground", Level: MapLevelFloor, RelationshipData: MapItemRelationshipData{AncestorIds: []string{"c1", "b2"}}},
+		// A parent that isn't in the response, and a duplicate that is ignored.
+		MapItem{ID: "c2", Name: "Other", Level: MapLevelCampus, RelationshipData: MapItemRelationshipData{Parent: "missing"}},
+		MapItem{ID: "f1", Name: "Duplicate"},
+	)
+	h := NewHierarchy(resp)
+
+	if got := nodeIDs(h.Roots()); got != "c1 c2" {
+		t.Errorf("roots %s, want c1 c2", got)
+	}
+	b1, ok := h.ByID("b1")
+	if !ok || nodeIDs(b1.Children()) != "f1 f2 f3" || b1.Parent().ID != "c1" {
+		t.Fatalf("b1 = %+v", b1)
+	}
+	if n, ok := h.ByID("f1"); !ok || n.Name != "Ground" {
+		t.Errorf("f1 = %+v, want the first seen", n)
+	}
+	if n, ok := h.ByImportedID("imp-b2"); !ok || n.ID != "b2" || nodeIDs(n.Children()) != "f4" {
+		t.Errorf("imported b2 = %+v", n)
+	}
+	if got := nodeIDs(h.FindByName("GROUND")); got != "f1 f4" {
+		t.Errorf("FindByName = %s, want f1 f4", got)
+	}
+
+	f4, _ := h.ByID("f4")
+	if f4.Path() != "Campus/Annex/Ground" || nodeIDs(f4.Ancestors()) != "c1 b2" {
+		t.Errorf("path %s with ancestors %s", f4.Path(), nodeIDs(f4.Ancestors()))
+	}
+	if c, ok := f4.Campus(); !ok || c.ID != "c1" {
+		t.Errorf("campus of f4 = %v", c)
+	}
+	if b, ok := f4.Building(); !ok || b.ID != "b2" {
+		t.Errorf("building of f4 = %v", b)
+	}
+	if f, ok := f4.Floor(); !ok || f.Number() != 0 {
+		t.Errorf("floor f4 = %v", f)
+	}
+	if _, ok := b1.Floor(); ok {
+		t.Error("b1 should not be a floor")
+	}
+
+	if n := len(h.Campuses()); n != 2 {
+		t.Errorf("%d campuses, want 2", n)
+	}
+	if n := len(h.Buildings()); n != 2 {
+		t.Errorf("%d buildings, want 2", n)
+	}
+	if n := len(h.Floors()); n != 4 {
+		t.Errorf("%d floors, want 4", n)
+	}
+	c1, _ := h.ByID("c1")
+	if n, m := len(Campus{c1}.Buildings()), len(Campus{c1}.Floors()); n != 2 || m != 4 {
+		t.Errorf("campus has %d buildings and %d floors, want 2 and 4", n, m)
+	}
+	if n := len(Building{b1}.Floors()); n != 3 {
+		t.Errorf("b1 has %d floors, want 3", n)
+	}
+}
+
+func TestNewHierarchyCycle(t *testing.T) {
+	// Items that are each other's parent, or their own, are still reachable rather than lost to the cycle.
+	h := NewHierarchy(MapHierarchyResponse{Map: []MapItem{
+		{ID: "a", RelationshipData: MapItemRelationshipData{Parent: "b"}},
+		{ID: "b", RelationshipData: MapItemRelationshipData{Parent: "a"}},
+		{ID: "c", RelationshipData: MapItemRelationshipData{Parent: "c"}},
+	}})
+	var walked []*HierarchyNode
+	h.Walk(func(n *HierarchyNode) error {
+		walked = append(walked, n)
+		return nil
+	})
+	if len(walked) != 3 {
+		t.Errorf("walked %s, want every node once", nodeIDs(walked))
+	}
+}
+
+func TestHierarchyWalk(t *testing.T) {
+	h := NewHierarchy(testHierarchy())
+	var walked []*HierarchyNode
+	err := h.Walk(func(n *HierarchyNode) error {
+		walked = append(walked, n)
+		return nil
+	})
+	if err != nil || nodeIDs(walked) != "c1 b1 f1 f2" {
+		t.Errorf("walked %s, %v, want parents before children", nodeIDs(walked), err)
+	}
+
+	walked = nil
+	h.Walk(func(n *HierarchyNode) error {
+		walked = append(walked, n)
+		if n.IsBuilding() {
+			return ErrSkipChildren
+		}
+		return nil
+	})
+	if nodeIDs(walked) != "c1 b1" {
+		t.Errorf("walked %s, want the floors skipped", nodeIDs(walked))
+	}
+
+	stop := errors.New("stop")
+	walked = nil
+	err = h.Walk(func(n *HierarchyNode) error {
+		walked = append(walked, n)
+		if n.ID == "f1" {
+			return stop
+		}
+		return nil
+	})
+	if err != stop || nodeIDs(walked) != "c1 b1 f1" {
+		t.Errorf("walked %s, %v, want the walk stopped at f1", nodeIDs(walked), err)
+	}
+}
+
+func TestHierarchyFindByPath(t *testing.T) {
+	h := NewHierarchy(testHierarchy())
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"Campus/Building/First", "f2"},
+		{"/campus/BUILDING/ground/", "f1"},
+		{"Campus", "c1"},
+		{"Building/First", ""},
+		{"Campus/Building/Third", ""},
+		{"Campus/Building/First/Room", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		var got string
+		if n, ok := h.FindByPath(tt.path); ok {
+			got = n.ID
+		}
+		if got != tt.want {
+			t.Errorf("FindByPath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
@@ -4,6 +4,9 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // HistoryParameters represent the options for GetHistory()
@@ -153,7 +156,10 @@ type HistoryClientsResponse []struct {
 }
 
 // HistoryClientsDeviceResponse  contains the response from GetClient()
-type HistoryClientsDeviceResponse []struct {
+type HistoryClientsDeviceResponse []HistoryClientsDevice
+
+// HistoryClientsDevice represents a single location of the device in the HistoryClientsDeviceResponse
+type HistoryClientsDevice struct {
 	FloorID         string    `json:"floorId"`
 	SourceTimestamp int64     `json:"sourceTimestamp"`
 	Coordinates     []float64 `json:"coordinates"`
@@ -201,6 +207,36 @@ type HistoryItem struct {
 	MacHashed               string `json:"machashed"`
 }
 
+// Timestamp returns the SourceTimestamp of the item as a time.Time.
+// DNA Spaces provides this as milliseconds since the epoch.
+func (h HistoryItem) Timestamp() (time.Time, error) {
+	ms, err := strconv.ParseInt(h.SourceTimestamp, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("dnas: invalid source timestamp %q: %w", h.SourceTimestamp, err)
+	}
+	return millisToTime(ms), nil
+}
+
+// IsAssociated reports whether the Associated field of the item is "true".
+func (h HistoryItem) IsAssociated() bool {
+	return strings.EqualFold(h.Associated, "true")
+}
+
+// IsStatic reports whether the StaticDevice field of the item is "true".
+func (h HistoryItem) IsStatic() bool {
+	return strings.EqualFold(h.StaticDevice, "true")
+}
+
+// millisToTime converts milliseconds since the epoch, as used throughout the DNA Spaces API, to a time.Time.
+func millisToTime(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
+// timeToMillis converts a time.Time to milliseconds since the epoch, as used throughout the DNA Spaces API.
+func timeToMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
 // GetHistory retrieves a small amount of clients history to csv format.
 // If startTime and endTime is not given, the time period is last 24 hours.
 // If records amount is more than 50K, the user receives error response and indicates the time range needs to be reduced.
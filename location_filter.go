@@ -0,0 +1,195 @@
+package dnas
+
+import (
+	"math"
+	"strings"
+)
+
+// BoundsAction represents what a LocationFilter does with devices located outside their floor
+type BoundsAction int
+
+// Fields for BoundsAction
+const (
+	// BoundsIgnore does not check the location against the floor.
+	BoundsIgnore BoundsAction = iota
+	// BoundsClamp moves the location to the nearest point on the floor.
+	BoundsClamp
+	// BoundsDrop removes the device.
+	BoundsDrop
+)
+
+// FilterReason represents why a LocationFilter removed a device
+type FilterReason string
+
+// Fields for FilterReason
+const (
+	FilteredTooFewAPs     FilterReason = "TOO_FEW_APS"
+	FilteredLowConfidence FilterReason = "LOW_CONFIDENCE"
+	FilteredWeakSignal    FilterReason = "WEAK_SIGNAL"
+	FilteredComputeType   FilterReason = "COMPUTE_TYPE"
+	FilteredNoCoordinates FilterReason = "NO_COORDINATES"
+	FilteredOutOfBounds   FilterReason = "OUT_OF_BOUNDS"
+)
+
+// LocationFilter removes low quality and out of bounds locations from active client results.
+// Checks are applied in the order of the fields below, and a zero value disables each check.
+type LocationFilter struct {
+	// MinDetectingAPs removes devices detected by fewer access points.
+	MinDetectingAPs int64
+
+	// MaxConfidence removes devices whose confidence factor, the radius of the location's uncertainty, is larger.
+	MaxConfidence int64
+
+	// MinRSSI removes devices whose strongest detected RSSI is weaker, e.g. -80.  Devices without an RSSI are kept.
+	MinRSSI int64
+
+	// ComputeTypes, if set, removes devices whose compute type is not in the list, e.g. RSSI or AOA.
+	ComputeTypes []string
+
+	// Bounds selects what to do with devices located outside their floor.  Floors are looked up in Hierarchy,
+	// and devices on floors that cannot be found are kept and counted in FilterStats.UnknownFloor.
+	Bounds BoundsAction
+
+	// Regions uses the floor's inclusion and exclusion regions when checking bounds, rather than only its dimensions.
+	Regions bool
+
+	// Unit of the device coordinates.  If empty, feet is used.  Coordinates are converted to the floor's unit, as given
+	// by MapItemDetails.Unit, to be checked and clamped, and clamped coordinates are converted back.
+	Unit Unit
+
+	// Hierarchy is used to find the dimensions and regions of each floor when checking bounds.
+	Hierarchy *Hierarchy
+}
+
+// FilterStats reports what a LocationFilter did.
+type FilterStats struct {
+	Input        int                  `json:"input"`
+	Kept         int                  `json:"kept"`
+	Clamped      int                  `json:"clamped"`
+	UnknownFloor int                  `json:"unknownFloor"`
+	Removed      map[FilterReason]int `json:"removed"`
+}
+
+// Apply filters the devices, returning those that pass along with statistics on what was removed and why.
+// The devices given are not modified; clamped devices are returned with new coordinates.
+func (f *LocationFilter) Apply(devices []LocationDevice) ([]LocationDevice, FilterStats) {
+	stats := FilterStats{Input: len(devices), Removed: make(map[FilterReason]int)}
+	kept := make([]LocationDevice, 0, len(devices))
+	for _, d := range devices {
+		reason, clamped := f.check(&d, &stats)
+		if reason != "" {
+			stats.Removed[reason]++
+			continue
+		}
+		if clamped {
+			stats.Clamped++
+		}
+		kept = append(kept, d)
+	}
+	stats.Kept = len(kept)
+	return kept, stats
+}
+
+// check returns the reason the device should be removed, or an empty string if it should be kept, and whether its
+// coordinates were clamped.
+func (f *LocationFilter) check(d *LocationDevice, stats *FilterStats) (FilterReason, bool) {
+	if f.MinDetectingAPs > 0 && d.NumDetectingAps < f.MinDetectingAPs {
+		return FilteredTooFewAPs, false
+	}
+	if f.MaxConfidence > 0 && d.ConfidenceFactor > f.MaxConfidence {
+		return FilteredLowConfidence, false
+	}
+	if f.MinRSSI != 0 && d.MaxDetectedRssi.Rssi != 0 && d.MaxDetectedRssi.Rssi < f.MinRSSI {
+		return FilteredWeakSignal, false
+	}
+	if len(f.ComputeTypes) > 0 {
+		allowed := false
+		for _, t := range f.ComputeTypes {
+			if strings.EqualFold(t, d.ComputeType) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return FilteredComputeType, false
+		}
+	}
+	if f.Bounds == BoundsIgnore {
+		return "", false
+	}
+	if len(d.Coordinates) < 2 {
+		return FilteredNoCoordinates, false
+	}
+	var floor *HierarchyNode
+	if f.Hierarchy != nil {
+		floor, _ = f.Hierarchy.ByID(d.FloorID)
+	}
+	if floor == nil {
+		stats.UnknownFloor++
+		return "", false
+	}
+	unit := f.Unit
+	if unit == "" {
+		unit = UnitFeet
+	}
+	details := floor.Details
+	floorUnit := details.Unit()
+	x, y := unit.Convert(d.Coordinates[0], floorUnit), unit.Convert(d.Coordinates[1], floorUnit)
+	inside := details.InBounds(x, y)
+	if f.Regions {
+		inside = details.Contains(x, y, floorUnit)
+	}
+	if inside {
+		return "", false
+	}
+	if f.Bounds == BoundsDrop {
+		return FilteredOutOfBounds, false
+	}
+	cx, cy := f.clamp(details, floorUnit, x, y)
+	coords := append([]float64{floorUnit.Convert(cx, unit), floorUnit.Convert(cy, unit)}, d.Coordinates[2:]...)
+	d.Coordinates = coords
+	return "", true
+}
+
+// clamp returns the nearest point on the floor to the given point, which is in the floor's unit.
+func (f *LocationFilter) clamp(details MapItemDetails, unit Unit, x, y float64) (float64, float64) {
+	if f.Regions {
+		if inclusions := details.InclusionRegions(); len(inclusions) > 0 {
+			included := false
+			for _, r := range inclusions {
+				if r.Contains(x, y, unit) {
+					included = true
+					break
+				}
+			}
+			if !included {
+				best := math.Inf(1)
+				bx, by := x, y
+				for _, r := range inclusions {
+					if len(r.Corners) < 3 {
+						continue
+					}
+					px, py := nearestPointOnPolygon(x, y, r.Polygon(unit))
+					if d := math.Hypot(x-px, y-py); d < best {
+						bx, by, best = px, py, d
+					}
+				}
+				x, y = bx, by
+			}
+		} else {
+			x, y = clampToBounds(details, x, y)
+		}
+		for _, r := range details.ExclusionRegions() {
+			if r.Contains(x, y, unit) {
+				x, y = nearestPointOnPolygon(x, y, r.Polygon(unit))
+			}
+		}
+		return x, y
+	}
+	return clampToBounds(details, x, y)
+}
+
+// clampToBounds returns the nearest point within the floor's dimensions.
+func clampToBounds(d MapItemDetails, x, y float64) (float64, float64) {
+	return math.Max(d.OffsetX, math.Min(d.OffsetX+d.Width, x)), math.Max(d.OffsetY, math.Min(d.OffsetY+d.Length, y))
+}
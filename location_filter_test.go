@@ -0,0 +1,101 @@
+package dnas
+
+import (
+	"math"
+	"testing"
+)
+
+func TestLocationFilterQuality(t *testing.T) {
+	f := &LocationFilter{MinDetectingAPs: 3, MaxConfidence: 30, MinRSSI: -80, ComputeTypes: []string{"RSSI"}}
+	device := func(aps, confidence, rssi int64, compute string) LocationDevice {
+		d := LocationDevice{NumDetectingAps: aps, ConfidenceFactor: confidence, ComputeType: compute}
+		d.MaxDetectedRssi.Rssi = rssi
+		return d
+	}
+	kept, stats := f.Apply([]LocationDevice{
+		device(3, 30, -80, "rssi"),
+		device(2, 10, -50, "RSSI"),
+		device(4, 40, -50, "RSSI"),
+		device(4, 10, -90, "RSSI"),
+		device(4, 10, 0, "AOA"),
+	})
+	if len(kept) != 1 || stats.Input != 5 || stats.Kept != 1 {
+		t.Errorf("kept %d of %d, want 1 of 5", stats.Kept, stats.Input)
+	}
+	for _, reason := range []FilterReason{FilteredTooFewAPs, FilteredLowConfidence, FilteredWeakSignal, FilteredComputeType} {
+		if stats.Removed[reason] != 1 {
+			t.Errorf("removed %d for %s, want 1", stats.Removed[reason], reason)
+		}
+	}
+}
+
+func TestLocationFilterBounds(t *testing.T) {
+	h := NewHierarchy(testHierarchy())
+	devices := []LocationDevice{
+		{FloorID: "f1", Coordinates: []float64{50, 25}},
+		{FloorID: "f1", Coordinates: []float64{150, -10, 3}},
+		{FloorID: "f1"},
+		{FloorID: "unknown", Coordinates: []float64{500, 500}},
+	}
+
+	f := &LocationFilter{Bounds: BoundsDrop, Hierarchy: h}
+	kept, stats := f.Apply(devices)
+	if len(kept) != 2 || stats.Removed[FilteredOutOfBounds] != 1 || stats.Removed[FilteredNoCoordinates] != 1 || stats.UnknownFloor != 1 {
+		t.Errorf("drop: kept %d, stats %+v", len(kept), stats)
+	}
+
+	f.Bounds = BoundsClamp
+	kept, stats = f.Apply(devices)
+	if len(kept) != 3 || stats.Clamped != 1 {
+		t.Fatalf("clamp: kept %d, stats %+v", len(kept), stats)
+	}
+	if c := kept[1].Coordinates; c[0] != 100 || c[1] != 0 || c[2] != 3 {
+		t.Errorf("clamped = %v, want [100 0 3]", c)
+	}
+	if devices[1].Coordinates[0] != 150 {
+		t.Error("Apply modified the devices given")
+	}
+}
+
+func TestLocationFilterUnits(t *testing.T) {
+	// The test floors are 100 by 50 feet, so 40 meters is outside them.
+	h := NewHierarchy(testHierarchy())
+	f := &LocationFilter{Bounds: BoundsClamp, Unit: UnitMeters, Hierarchy: h}
+	kept, stats := f.Apply([]LocationDevice{
+		{FloorID: "f1", Coordinates: []float64{25, 10}},
+		{FloorID: "f1", Coordinates: []float64{40, 10}},
+	})
+	if stats.Clamped != 1 {
+		t.Fatalf("clamped %d, want 1", stats.Clamped)
+	}
+	if c := kept[0].Coordinates; c[0] != 25 || c[1] != 10 {
+		t.Errorf("inside = %v, want unchanged", c)
+	}
+	if c := kept[1].Coordinates; math.Abs(c[0]-100*metersPerFoot) > 1e-9 || math.Abs(c[1]-10) > 1e-9 {
+		t.Errorf("clamped = %v, want [%v 10] in meters", c, 100*metersPerFoot)
+	}
+}
+
+func TestLocationFilterRegions(t *testing.T) {
+	resp := testHierarchy()
+	floor := &resp.Map[0].RelationshipData.Children[0].RelationshipData.Children[0]
+	floor.Details.InclusionExclusionRegion = []MapInclusionExclusionRegionItem{
+		{Type: RegionInclusion, Corners: []MapItemCorner{{X: 0, Y: 0}, {X: 50, Y: 0}, {X: 50, Y: 50}, {X: 0, Y: 50}}},
+		{Type: RegionExclusion, Corners: []MapItemCorner{{X: 10, Y: 10}, {X: 20, Y: 10}, {X: 20, Y: 20}, {X: 10, Y: 20}}},
+	}
+	f := &LocationFilter{Bounds: BoundsClamp, Regions: true, Hierarchy: NewHierarchy(resp)}
+	kept, stats := f.Apply([]LocationDevice{
+		{FloorID: "f1", Coordinates: []float64{40, 40}},
+		{FloorID: "f1", Coordinates: []float64{80, 25}},
+		{FloorID: "f1", Coordinates: []float64{11, 15}},
+	})
+	if stats.Clamped != 2 {
+		t.Fatalf("clamped %d, want 2", stats.Clamped)
+	}
+	want := [][]float64{{40, 40}, {50, 25}, {10, 15}}
+	for i, w := range want {
+		if c := kept[i].Coordinates; math.Abs(c[0]-w[0]) > 1e-9 || math.Abs(c[1]-w[1]) > 1e-9 {
+			t.Errorf("device %d = %v, want %v", i, c, w)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package dnas
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FloorImageOptions provides the options for GetFloorImage.
+type FloorImageOptions struct {
+	// CacheDir, if set, is the directory used to cache floor images.  Verified images are saved there keyed by
+	// checksum and subsequent requests for the same checksum are served from disk.
+	CacheDir string
+}
+
+// GetFloorImage downloads the floor image for a map element, e.g. floor.Details.Image.
+// The image is returned as a stream which must be closed by the caller.
+//
+// If the image has a checksum, the downloaded data is verified against it and ErrChecksumMismatch is returned
+// from Read once the end of the image is reached if it does not match.  The checksum may be an MD5, SHA-1 or SHA-256
+// hex digest.  If ImageCompressed is set, the image is transparently decompressed.
+//
+// If opts.CacheDir is set, images with a checksum are cached.  Images without a checksum in a recognised format can't
+// be verified, so are never cached.  An error creating the cache file is returned before the download, and an error
+// writing it is returned from Close once the image has been read.
+func (s *MapService) GetFloorImage(ctx context.Context, image MapResImage, opts *FloorImageOptions) (io.ReadCloser, error) {
+	if image.ImageName == "" {
+		return nil, errors.New("dnas: image name required")
+	}
+	var cacheDir string
+	if opts != nil {
+		cacheDir = opts.CacheDir
+	}
+	cachePath := imageCachePath(cacheDir, image)
+	var cache *os.File
+	if cachePath != "" {
+		if f, err := os.Open(cachePath); err == nil {
+			if fi, err := f.Stat(); err == nil && fi.Mode().IsRegular() {
+				return f, nil
+			}
+			f.Close()
+		}
+		if err := os.MkdirAll(cacheDir, 0755); err != nil {
+			return nil, fmt.Errorf("dnas: caching floor image: %w", err)
+		}
+		tmp, err := ioutil.TempFile(cacheDir, ".download-")
+		if err != nil {
+			return nil, fmt.Errorf("dnas: caching floor image: %w", err)
+		}
+		cache = tmp
+	}
+
+	fi := &floorImageReader{cache: cache, cachePath: cachePath}
+	body, err := s.downloadFloorImage(ctx, image)
+	if err != nil {
+		fi.discardCache()
+		return nil, err
+	}
+	fi.body = body
+	var raw io.Reader = body
+	if h, want := checksumHash(image.Cksum); h != nil {
+		fi.verify = &checksumReader{r: body, h: h, want: want}
+		raw = fi.verify
+	}
+	fi.r = raw
+	if image.ImageCompressed {
+		dr, err := decompress(raw)
+		if err != nil {
+			fi.Close()
+			return nil, err
+		}
+		fi.r = dr
+	}
+	return fi, nil
+}
+
+// downloadFloorImage requests the image, returning the response body.
+func (s *MapService) downloadFloorImage(ctx context.Context, image MapResImage) (io.ReadCloser, error) {
+	url := fmt.Sprintf("%s/map/images/floor/%s", s.client.BaseURL, url.PathEscape(image.ImageName))
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.client.do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+// imageCachePath returns the path used to cache the image, or an empty string if the image should not be cached
+// because there is no cache directory or its checksum can't be verified.
+func imageCachePath(dir string, image MapResImage) string {
+	if dir == "" {
+		return ""
+	}
+	if h, _ := checksumHash(image.Cksum); h == nil {
+		return ""
+	}
+	clean := func(v string) string {
+		return strings.Map(func(r rune) rune {
+			if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+				return r
+			}
+			return -1
+		}, v)
+	}
+	name := clean(image.Cksum)
+	if name == "" {
+		return ""
+	}
+	if ext := clean(strings.TrimPrefix(filepath.Ext(image.ImageName), ".")); ext != "" {
+		name += "." + ext
+	}
+	return filepath.Join(dir, name)
+}
+
+// checksumHash returns the hash to use for the checksum and the expected sum, or nil if the format is not recognised.
+func checksumHash(cksum string) (hash.Hash, []byte) {
+	sum, err := hex.DecodeString(strings.TrimSpace(cksum))
+	if err != nil {
+		return nil, nil
+	}
+	switch len(sum) {
+	case md5.Size:
+		return md5.New(), sum
+	case sha1.Size:
+		return sha1.New(), sum
+	case sha256.Size:
+		return sha256.New(), sum
+	}
+	return nil, nil
+}
+
+// checksumReader hashes everything read from r and checks it against the expected sum at EOF.
+type checksumReader struct {
+	r    io.Reader
+	h    hash.Hash
+	want []byte
+}
+
+func (c *checksumReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.h.Write(p[:n])
+	if err == io.EOF && !bytes.Equal(c.h.Sum(nil), c.want) {
+		return n, ErrChecksumMismatch
+	}
+	return n, err
+}
+
+// decompress returns a reader that decompresses gzip or zlib data from r.
+func decompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	if magic[0] == 0x1f && magic[1] == 0x8b {
+		return gzip.NewReader(br)
+	}
+	return zlib.NewReader(br)
+}
+
+// floorImageReader streams a floor image, verifying the checksum and saving it to the cache as it is read.
+type floorImageReader struct {
+	body      io.ReadCloser
+	r         io.Reader
+	verify    *checksumReader
+	cache     *os.File
+	cachePath string
+	cacheErr  error
+}
+
+func (f *floorImageReader) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	if n > 0 && f.cache != nil {
+		if _, werr := f.cache.Write(p[:n]); werr != nil {
+			f.cacheErr = werr
+			f.discardCache()
+		}
+	}
+	if err == io.EOF && f.verify != nil && f.r != io.Reader(f.verify) {
+		// The decompressor may stop before the end of the underlying data, so drain it to complete the checksum.
+		if _, verr := io.Copy(ioutil.Discard, f.verify); verr != nil {
+			err = verr
+		}
+	}
+	if err == io.EOF && f.cache != nil {
+		name := f.cache.Name()
+		f.cacheErr = f.cache.Close()
+		if f.cacheErr == nil {
+			f.cacheErr = os.Rename(name, f.cachePath)
+		}
+		if f.cacheErr != nil {
+			os.Remove(name)
+		}
+		f.cache = nil
+	} else if err != nil && err != io.EOF {
+		f.discardCache()
+	}
+	return n, err
+}
+
+// Close closes the response body, discarding any partially cached image.  If the image was read but couldn't be
+// saved to the cache, the error is returned.
+func (f *floorImageReader) Close() error {
+	f.discardCache()
+	err := f.body.Close()
+	if f.cacheErr != nil {
+		return fmt.Errorf("dnas: caching floor image: %w", f.cacheErr)
+	}
+	return err
+}
+
+// discardCache removes the temporary cache file.
+func (f *floorImageReader) discardCache() {
+	if f.cache == nil {
+		return
+	}
+	f.cache.Close()
+	os.Remove(f.cache.Name())
+	f.cache = nil
+}
@@ -0,0 +1,163 @@
+package dnas
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+var testImage = []byte("\x89PNG floor image")
+
+// newImageServer returns a client for a server that sends body for every floor image, counting the requests.
+func newImageServer(t *testing.T, body []byte) (*Client, *int32) {
+	t.Helper()
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.URL.Path != "/map/images/floor/floor.png" {
+			t.Errorf("path = %s, want /map/images/floor/floor.png", r.URL.Path)
+		}
+		w.Write(body)
+	}))
+	t.Cleanup(srv.Close)
+	c, err := NewClient("test-key", "io", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.BaseURL = srv.URL
+	return c, &requests
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// readImage reads the floor image, returning the error from Read or Close.
+func readImage(c *Client, image MapResImage, opts *FloorImageOptions) ([]byte, error) {
+	r, err := c.MapService.GetFloorImage(context.Background(), image, opts)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadAll(r)
+	if cerr := r.Close(); err == nil {
+		err = cerr
+	}
+	return b, err
+}
+
+func TestFloorImageChecksum(t *testing.T) {
+	c, _ := newImageServer(t, testImage)
+	wrongMD5 := "8c1f1b0b8f6fe5e5d7e5ed1c0d1b1c6e"
+	tests := []struct {
+		cksum string
+		err   error
+	}{
+		{"", nil},
+		{sha256Hex(testImage), nil},
+		{sha256Hex([]byte("other")), ErrChecksumMismatch},
+		{wrongMD5, ErrChecksumMismatch},
+		// A decimal CRC-32 isn't a recognised format so can't be verified.
+		{"12345", nil},
+	}
+	for _, tt := range tests {
+		b, err := readImage(c, MapResImage{ImageName: "floor.png", Cksum: tt.cksum}, nil)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("checksum %q: err = %v, want %v", tt.cksum, err, tt.err)
+		}
+		if !bytes.Equal(b, testImage) {
+			t.Errorf("checksum %q: read %q", tt.cksum, b)
+		}
+	}
+}
+
+func TestFloorImageDecompress(t *testing.T) {
+	var gz, zl bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write(testImage)
+	w.Close()
+	zw := zlib.NewWriter(&zl)
+	zw.Write(testImage)
+	zw.Close()
+
+	for name, compressed := range map[string][]byte{"gzip": gz.Bytes(), "zlib": zl.Bytes()} {
+		c, _ := newImageServer(t, compressed)
+		// The checksum is of the downloaded, compressed, data.
+		image := MapResImage{ImageName: "floor.png", ImageCompressed: true, Cksum: sha256Hex(compressed)}
+		b, err := readImage(c, image, nil)
+		if err != nil || !bytes.Equal(b, testImage) {
+			t.Errorf("%s: read %q, %v", name, b, err)
+		}
+		image.Cksum = sha256Hex(testImage)
+		if _, err := readImage(c, image, nil); !errors.Is(err, ErrChecksumMismatch) {
+			t.Errorf("%s: err = %v, want ErrChecksumMismatch", name, err)
+		}
+	}
+}
+
+func TestFloorImageCache(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "images")
+	defer os.RemoveAll(dir)
+	c, requests := newImageServer(t, testImage)
+	opts := &FloorImageOptions{CacheDir: filepath.Join(dir, "cache")}
+	image := MapResImage{ImageName: "floor.png", Cksum: sha256Hex(testImage)}
+
+	for i := 0; i < 2; i++ {
+		if b, err := readImage(c, image, opts); err != nil || !bytes.Equal(b, testImage) {
+			t.Fatalf("read %q, %v", b, err)
+		}
+	}
+	if *requests != 1 {
+		t.Errorf("%d requests, want the second image read from the cache", *requests)
+	}
+	files, _ := ioutil.ReadDir(opts.CacheDir)
+	if len(files) != 1 || files[0].Name() != image.Cksum+".png" {
+		t.Errorf("cache has %v, want only the image", files)
+	}
+
+	// Images that fail verification or have no checksum aren't cached.
+	for _, cksum := range []string{sha256Hex([]byte("other")), ""} {
+		readImage(c, MapResImage{ImageName: "floor.png", Cksum: cksum}, opts)
+	}
+	if files, _ := ioutil.ReadDir(opts.CacheDir); len(files) != 1 {
+		t.Errorf("cache has %d files, want 1", len(files))
+	}
+}
+
+func TestFloorImageCacheErrors(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "images")
+	defer os.RemoveAll(dir)
+	c, requests := newImageServer(t, testImage)
+	image := MapResImage{ImageName: "floor.png", Cksum: sha256Hex(testImage)}
+
+	// The cache directory can't be created.
+	file := filepath.Join(dir, "file")
+	ioutil.WriteFile(file, nil, 0644)
+	if _, err := c.MapService.GetFloorImage(context.Background(), image, &FloorImageOptions{CacheDir: file}); err == nil {
+		t.Error("expected error for a cache directory that is a file")
+	}
+	if *requests != 0 {
+		t.Errorf("%d requests, want none", *requests)
+	}
+
+	// The image can't be moved into place because a directory is in the way.
+	os.MkdirAll(filepath.Join(dir, image.Cksum+".png", "x"), 0755)
+	b, err := readImage(c, image, &FloorImageOptions{CacheDir: dir})
+	if err == nil || !bytes.Equal(b, testImage) {
+		t.Errorf("read %q, %v, want the image and an error from Close", b, err)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, ".download-*")); len(files) != 0 {
+		t.Errorf("temporary files %v left in the cache", files)
+	}
+}
@@ -1,5 +1,12 @@
 package dnas
 
+// Map element levels as provided in MapItem.Level
+const (
+	MapLevelCampus   = "CAMPUS"
+	MapLevelBuilding = "BUILDING"
+	MapLevelFloor    = "FLOOR"
+)
+
 // MapHierarchyResponse is the top level response for GetHierarchy
 type MapHierarchyResponse struct {
 	// Top level item for Map Items
@@ -55,7 +62,8 @@ type MapItemDetails struct {
 	// image
 	Image MapResImage `json:"image,omitempty"`
 
-	InclusionExclusionRegion []map[string]interface{} `json:"inclusionExclusionRegion,omitempty"`
+	// Regions of the floor where devices can or cannot be located.
+	InclusionExclusionRegion []MapInclusionExclusionRegionItem `json:"inclusionExclusionRegion,omitempty"`
 
 	Latitude float64 `json:"latitude,omitempty"`
 
@@ -74,8 +82,9 @@ type MapItemDetails struct {
 	Width float64 `json:"width,omitempty"`
 }
 
-// MapInclusionExclusionRegionItem is supposed to represent an InclusionExclusionRegionItem.
-// This is not currently used, but you could use it to extract InclusionExclusionRegionItems to.
+// MapInclusionExclusionRegionItem represents an inclusion or exclusion region on a floor.
+// The region is a polygon whose corners are decoded into Corners.  The original data is kept in Raw
+// so that any additional items are still available.
 type MapInclusionExclusionRegionItem struct {
 
 	// Indicate how to handle the vertices on map.
@@ -83,10 +92,15 @@ type MapInclusionExclusionRegionItem struct {
 
 	// The number of vertices.
 	Vertices int64 `json:"vertices,omitempty"`
+
+	// Corners of the region in order.
+	Corners []MapItemCorner `json:"-"`
+
+	// Raw is the region as provided by DNA Spaces.
+	Raw map[string]interface{} `json:"-"`
 }
 
-// MapItemCorner is supposed to represent part of an InclusionExclusionRegionItem.
-// This is not currently used, but you could use it to extract InclusionExclusionRegionItems to.
+// MapItemCorner represents a single corner of a MapInclusionExclusionRegionItem.
 type MapItemCorner struct {
 	// x
 	X float64 `json:"x,omitempty"`
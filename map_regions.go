@@ -0,0 +1,195 @@
+package dnas
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Region types for MapInclusionExclusionRegionItem.Type
+const (
+	RegionInclusion = "INCLUSION"
+	RegionExclusion = "EXCLUSION"
+)
+
+// UnmarshalJSON decodes a region, collecting its corners from either a "corners" list or from the numbered keys
+// DNA Spaces uses for each vertex, e.g. "1", "2", "3".  Items that can't be decoded, such as a malformed corner, are
+// skipped rather than failing, and are still available in Raw.
+func (r *MapInclusionExclusionRegionItem) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	var item MapInclusionExclusionRegionItem
+	if err := json.Unmarshal(data, &item.Raw); err != nil {
+		return err
+	}
+	if v, ok := raw["type"]; ok {
+		json.Unmarshal(v, &item.Type)
+	}
+	if v, ok := raw["vertices"]; ok {
+		var n json.Number
+		if err := json.Unmarshal(v, &n); err == nil {
+			if f, err := n.Float64(); err == nil {
+				item.Vertices = int64(f)
+			}
+		}
+	}
+
+	var corners []json.RawMessage
+	if v, ok := raw["corners"]; ok {
+		json.Unmarshal(v, &corners)
+	} else {
+		var keys []int
+		for k := range raw {
+			if i, err := strconv.Atoi(k); err == nil {
+				keys = append(keys, i)
+			}
+		}
+		sort.Ints(keys)
+		for _, k := range keys {
+			corners = append(corners, raw[strconv.Itoa(k)])
+		}
+	}
+	for _, v := range corners {
+		var c MapItemCorner
+		if err := json.Unmarshal(v, &c); err == nil {
+			item.Corners = append(item.Corners, c)
+		}
+	}
+	*r = item
+	return nil
+}
+
+// MarshalJSON encodes the region as it was provided by DNA Spaces.  If the type, vertices or corners have been
+// changed since, they replace those in the raw data, keeping any additional items.  If there is no raw data,
+// the type, vertices and corners are encoded using numbered keys for each corner.
+func (r MapInclusionExclusionRegionItem) MarshalJSON() ([]byte, error) {
+	if r.Raw == nil {
+		return json.Marshal(r.fields(map[string]interface{}{}, false))
+	}
+	b, err := json.Marshal(r.Raw)
+	if err != nil {
+		return nil, err
+	}
+	var orig MapInclusionExclusionRegionItem
+	if err := json.Unmarshal(b, &orig); err != nil {
+		return nil, err
+	}
+	if orig.Type == r.Type && orig.Vertices == r.Vertices && reflect.DeepEqual(orig.Corners, r.Corners) {
+		return b, nil
+	}
+	m := make(map[string]interface{}, len(r.Raw))
+	_, corners := r.Raw["corners"]
+	for k, v := range r.Raw {
+		if _, err := strconv.Atoi(k); err == nil && !corners {
+			continue
+		}
+		m[k] = v
+	}
+	return json.Marshal(r.fields(m, corners))
+}
+
+// fields sets the type, vertices and corners of the region in m, removing those that are empty.  The corners are
+// set as a "corners" list, or using numbered keys for each corner.
+func (r MapInclusionExclusionRegionItem) fields(m map[string]interface{}, list bool) map[string]interface{} {
+	delete(m, "type")
+	if r.Type != "" {
+		m["type"] = r.Type
+	}
+	delete(m, "vertices")
+	if r.Vertices != 0 {
+		m["vertices"] = r.Vertices
+	}
+	if list {
+		m["corners"] = r.Corners
+		return m
+	}
+	for i, c := range r.Corners {
+		m[strconv.Itoa(i+1)] = c
+	}
+	return m
+}
+
+// IsInclusion reports whether the region is an inclusion region.
+func (r MapInclusionExclusionRegionItem) IsInclusion() bool {
+	return strings.EqualFold(r.Type, RegionInclusion)
+}
+
+// IsExclusion reports whether the region is an exclusion region.
+func (r MapInclusionExclusionRegionItem) IsExclusion() bool {
+	return strings.EqualFold(r.Type, RegionExclusion)
+}
+
+// Polygon returns the corners of the region converted to the given unit.
+func (r MapInclusionExclusionRegionItem) Polygon(unit Unit) []MapItemCorner {
+	poly := make([]MapItemCorner, len(r.Corners))
+	for i, c := range r.Corners {
+		poly[i] = c.In(unit)
+	}
+	return poly
+}
+
+// Contains reports whether the point, given in the unit provided, is inside the region.
+func (r MapInclusionExclusionRegionItem) Contains(x, y float64, unit Unit) bool {
+	return len(r.Corners) >= 3 && pointInPolygon(x, y, r.Polygon(unit))
+}
+
+// InclusionRegions returns the inclusion regions of the floor.
+func (d MapItemDetails) InclusionRegions() []MapInclusionExclusionRegionItem {
+	var regions []MapInclusionExclusionRegionItem
+	for _, r := range d.InclusionExclusionRegion {
+		if r.IsInclusion() {
+			regions = append(regions, r)
+		}
+	}
+	return regions
+}
+
+// ExclusionRegions returns the exclusion regions of the floor.
+func (d MapItemDetails) ExclusionRegions() []MapInclusionExclusionRegionItem {
+	var regions []MapInclusionExclusionRegionItem
+	for _, r := range d.InclusionExclusionRegion {
+		if r.IsExclusion() {
+			regions = append(regions, r)
+		}
+	}
+	return regions
+}
+
+// InBounds reports whether the point is within the floor's dimensions, starting from OffsetX and OffsetY.
+func (d MapItemDetails) InBounds(x, y float64) bool {
+	return x >= d.OffsetX && x <= d.OffsetX+d.Width && y >= d.OffsetY && y <= d.OffsetY+d.Length
+}
+
+// Contains reports whether the point, given in the unit provided, is inside the floor's inclusion area and outside
+// every exclusion area.  If the floor has no inclusion regions, the floor's dimensions are used as the inclusion area,
+// converting the point to the floor's unit.
+func (d MapItemDetails) Contains(x, y float64, unit Unit) bool {
+	inclusions := d.InclusionRegions()
+	if len(inclusions) == 0 {
+		floorUnit := d.Unit()
+		if !d.InBounds(unit.Convert(x, floorUnit), unit.Convert(y, floorUnit)) {
+			return false
+		}
+	} else {
+		included := false
+		for _, r := range inclusions {
+			if r.Contains(x, y, unit) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+	for _, r := range d.ExclusionRegions() {
+		if r.Contains(x, y, unit) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,101 @@
+package dnas
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRegionUnmarshal(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		vertices int64
+		corners  int
+	}{
+		{"numbered", `{"type":"INCLUSION","vertices":3,"1":{"x":0,"y":0},"2":{"x":10,"y":0},"3":{"x":0,"y":10}}`, 3, 3},
+		{"list", `{"type":"INCLUSION","vertices":3,"corners":[{"x":0,"y":0},{"x":10,"y":0},{"x":0,"y":10}]}`, 3, 3},
+		{"string vertices", `{"type":"INCLUSION","vertices":"3","1":{"x":0,"y":0},"2":{"x":10,"y":0},"3":{"x":0,"y":10}}`, 3, 3},
+		{"bad vertices", `{"type":"INCLUSION","vertices":"many","1":{"x":0,"y":0}}`, 0, 1},
+		{"bad corner", `{"type":"INCLUSION","vertices":3,"1":{"x":0,"y":0},"2":"corner","3":{"x":0,"y":10}}`, 3, 2},
+		{"bad corners list", `{"type":"INCLUSION","corners":{"x":0}}`, 0, 0},
+	}
+	for _, tt := range tests {
+		var r MapInclusionExclusionRegionItem
+		if err := json.Unmarshal([]byte(tt.data), &r); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !r.IsInclusion() || r.Vertices != tt.vertices || len(r.Corners) != tt.corners || r.Raw == nil {
+			t.Errorf("%s: region = %+v, want %d vertices and %d corners", tt.name, r, tt.vertices, tt.corners)
+		}
+	}
+}
+
+func TestRegionMarshal(t *testing.T) {
+	data := `{"1":{"unit":"FEET","x":0,"y":0},"2":{"unit":"FEET","x":10,"y":0},"3":{"unit":"FEET","x":0,"y":10},"extra":"x","type":"INCLUSION","vertices":3}`
+	var r MapInclusionExclusionRegionItem
+	if err := json.Unmarshal([]byte(data), &r); err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != data {
+		t.Errorf("unchanged region = %s, want %s", b, data)
+	}
+
+	r.Type = RegionExclusion
+	r.Corners = r.Corners[1:]
+	b, err = json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"1":{"x":10,"unit":"FEET"},"2":{"y":10,"unit":"FEET"},"extra":"x","type":"EXCLUSION","vertices":3}`
+	if string(b) != want {
+		t.Errorf("edited region = %s, want %s", b, want)
+	}
+}
+
+func TestDetailsContains(t *testing.T) {
+	square := MapInclusionExclusionRegionItem{Type: RegionInclusion, Corners: []MapItemCorner{
+		{X: 0, Y: 0, Unit: "FEET"}, {X: 50, Y: 0, Unit: "FEET"}, {X: 50, Y: 50, Unit: "FEET"}, {X: 0, Y: 50, Unit: "FEET"},
+	}}
+	hole := MapInclusionExclusionRegionItem{Type: RegionExclusion, Corners: []MapItemCorner{
+		{X: 10, Y: 10, Unit: "FEET"}, {X: 20, Y: 10, Unit: "FEET"}, {X: 20, Y: 20, Unit: "FEET"},
+	}}
+	d := MapItemDetails{Width: 100, Length: 100, InclusionExclusionRegion: []MapInclusionExclusionRegionItem{square, hole}}
+	tests := []struct {
+		x, y float64
+		unit Unit
+		want bool
+	}{
+		{40, 40, UnitFeet, true},
+		{60, 40, UnitFeet, false},
+		{18, 12, UnitFeet, false},
+		{12, 12, UnitMeters, true},
+		{20, 20, UnitMeters, false},
+	}
+	for _, tt := range tests {
+		if got := d.Contains(tt.x, tt.y, tt.unit); got != tt.want {
+			t.Errorf("Contains(%v, %v, %s) = %v, want %v", tt.x, tt.y, tt.unit, got, tt.want)
+		}
+	}
+
+	// Without regions the point is converted to the floor's unit, feet by default.
+	d = MapItemDetails{Width: 100, Length: 100}
+	if !d.Contains(20, 20, UnitMeters) || d.Contains(40, 40, UnitMeters) || !d.Contains(90, 90, "") {
+		t.Error("Contains without regions ignored the unit")
+	}
+}
+
+func TestCornerIn(t *testing.T) {
+	c := MapItemCorner{X: 10}.In(UnitMeters)
+	if c.X != 10 || c.Unit != string(UnitMeters) {
+		t.Errorf("corner without unit = %+v, want 10 METERS", c)
+	}
+	c = MapItemCorner{X: 10, Unit: "FEET"}.In(UnitMeters)
+	if c.X != 10*metersPerFoot || c.Unit != string(UnitMeters) {
+		t.Errorf("corner in feet = %+v, want %v METERS", c, 10*metersPerFoot)
+	}
+}
@@ -0,0 +1,55 @@
+package mapexport
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/darrenparkinson/dnas"
+)
+
+// FeatureCollection is a GeoJSON FeatureCollection.
+type FeatureCollection struct {
+	Type     string    `json:"type"`
+	Features []Feature `json:"features"`
+}
+
+// Feature is a GeoJSON Feature representing a single map element.
+type Feature struct {
+	Type       string     `json:"type"`
+	ID         string     `json:"id,omitempty"`
+	Geometry   *Geometry  `json:"geometry"`
+	Properties Properties `json:"properties"`
+}
+
+// Geometry is a GeoJSON Point or Polygon geometry.
+type Geometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+// GeoJSON returns the hierarchy as a GeoJSON FeatureCollection with one feature per map element.
+func GeoJSON(h *dnas.Hierarchy, opts Options) FeatureCollection {
+	fc := FeatureCollection{Type: "FeatureCollection", Features: []Feature{}}
+	for _, e := range elements(h, opts) {
+		f := Feature{Type: "Feature", ID: e.props.ID, Properties: e.props}
+		switch {
+		case e.outline != nil:
+			ring := make([][]float64, len(e.outline))
+			for i, c := range e.outline {
+				ring[i] = []float64{c[0], c[1]}
+			}
+			f.Geometry = &Geometry{Type: "Polygon", Coordinates: [][][]float64{ring}}
+		case e.point != nil:
+			f.Geometry = &Geometry{Type: "Point", Coordinates: []float64{e.point[0], e.point[1]}}
+		}
+		fc.Features = append(fc.Features, f)
+	}
+	return fc
+}
+
+// WriteGeoJSON writes the hierarchy as an indented GeoJSON FeatureCollection.
+func WriteGeoJSON(w io.Writer, h *dnas.Hierarchy, opts Options) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(GeoJSON(h, opts))
+}
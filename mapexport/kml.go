@@ -0,0 +1,109 @@
+package mapexport
+
+import (
+	"encoding/xml"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/darrenparkinson/dnas"
+)
+
+// kmlDocument is the root of a KML file.
+type kmlDocument struct {
+	XMLName  xml.Name `xml:"kml"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Document struct {
+		Name       string         `xml:"name"`
+		Placemarks []kmlPlacemark `xml:"Placemark"`
+	} `xml:"Document"`
+}
+
+// kmlPlacemark is a single map element.
+type kmlPlacemark struct {
+	ID           string      `xml:"id,attr,omitempty"`
+	Name         string      `xml:"name"`
+	Address      string      `xml:"address,omitempty"`
+	Description  string      `xml:"description,omitempty"`
+	ExtendedData []kmlData   `xml:"ExtendedData>Data"`
+	Point        *kmlPoint   `xml:"Point,omitempty"`
+	Polygon      *kmlPolygon `xml:"Polygon,omitempty"`
+}
+
+// kmlData is a single named value in ExtendedData.
+type kmlData struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value"`
+}
+
+// kmlPoint is a KML Point.
+type kmlPoint struct {
+	Coordinates string `xml:"coordinates"`
+}
+
+// kmlPolygon is a KML Polygon with only an outer boundary.
+type kmlPolygon struct {
+	Coordinates string `xml:"outerBoundaryIs>LinearRing>coordinates"`
+}
+
+// WriteKML writes the hierarchy as a KML document with one placemark per map element.
+func WriteKML(w io.Writer, h *dnas.Hierarchy, opts Options) error {
+	doc := kmlDocument{Xmlns: "http://www.opengis.net/kml/2.2"}
+	doc.Document.Name = "DNA Spaces Map Hierarchy"
+	for _, e := range elements(h, opts) {
+		p := kmlPlacemark{
+			ID:          e.props.ID,
+			Name:        e.props.Name,
+			Address:     e.props.Address,
+			Description: e.props.Path,
+		}
+		add := func(name, value string) {
+			if value != "" {
+				p.ExtendedData = append(p.ExtendedData, kmlData{Name: name, Value: value})
+			}
+		}
+		formatFloat := func(v float64) string {
+			if v == 0 {
+				return ""
+			}
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		}
+		add("level", e.props.Level)
+		add("id", e.props.ID)
+		add("importedId", e.props.ImportedID)
+		add("parentId", e.props.ParentID)
+		if e.props.FloorNumber != nil {
+			add("floorNumber", strconv.FormatInt(*e.props.FloorNumber, 10))
+		}
+		add("width", formatFloat(e.props.Width))
+		add("length", formatFloat(e.props.Length))
+		add("height", formatFloat(e.props.Height))
+		add("unit", e.props.Unit)
+		switch {
+		case e.outline != nil:
+			p.Polygon = &kmlPolygon{Coordinates: kmlCoordinates(e.outline)}
+		case e.point != nil:
+			p.Point = &kmlPoint{Coordinates: kmlCoordinates([][2]float64{*e.point})}
+		}
+		doc.Document.Placemarks = append(doc.Document.Placemarks, p)
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// kmlCoordinates formats longitude/latitude pairs as a KML coordinates string.
+func kmlCoordinates(coords [][2]float64) string {
+	parts := make([]string, len(coords))
+	for i, c := range coords {
+		parts[i] = strconv.FormatFloat(c[0], 'f', -1, 64) + "," + strconv.FormatFloat(c[1], 'f', -1, 64)
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,121 @@
+// Package mapexport exports the DNA Spaces map hierarchy to GeoJSON and KML for use in GIS tools.
+//
+// Each campus, building and floor becomes a single feature with its name, level, identifiers, address, floor number
+// and dimensions.  Where a transform can be derived from the element's MapItemDetails, the outline of the element is
+// included as a polygon, or its location as a point if it has no dimensions.  Otherwise the feature has no geometry.
+package mapexport
+
+import (
+	"math"
+
+	"github.com/darrenparkinson/dnas"
+	"github.com/darrenparkinson/dnas/transform"
+)
+
+// Options control the export.
+type Options struct {
+	// Unit of the map dimensions and offsets of elements that don't give their own.  Each element's unit is taken
+	// from the corners of its inclusion and exclusion regions where they have one.  If empty, feet is used.
+	Unit dnas.Unit
+}
+
+// Properties are the attributes exported for each map element.
+type Properties struct {
+	Name        string  `json:"name"`
+	Level       string  `json:"level"`
+	ID          string  `json:"id"`
+	ImportedID  string  `json:"importedId,omitempty"`
+	ParentID    string  `json:"parentId,omitempty"`
+	Path        string  `json:"path"`
+	Address     string  `json:"address,omitempty"`
+	FloorNumber *int64  `json:"floorNumber,omitempty"`
+	Width       float64 `json:"width,omitempty"`
+	Length      float64 `json:"length,omitempty"`
+	Height      float64 `json:"height,omitempty"`
+	Unit        string  `json:"unit,omitempty"`
+}
+
+// element is a map element prepared for export.
+type element struct {
+	props Properties
+
+	// outline is a closed ring of longitude/latitude pairs, or nil.
+	outline [][2]float64
+
+	// point is a longitude/latitude pair, or nil.
+	point *[2]float64
+}
+
+// elements prepares every node in the hierarchy for export in walk order.
+func elements(h *dnas.Hierarchy, opts Options) []element {
+	defaultUnit := opts.Unit
+	if defaultUnit == "" {
+		defaultUnit = dnas.UnitFeet
+	}
+	var result []element
+	h.Walk(func(n *dnas.HierarchyNode) error {
+		unit := elementUnit(n.Details, defaultUnit)
+		e := element{props: Properties{
+			Name:       n.Name,
+			Level:      n.Level,
+			ID:         n.ID,
+			ImportedID: n.ImportedID,
+			Path:       n.Path(),
+			Address:    n.Address,
+			Width:      n.Details.Width,
+			Length:     n.Details.Length,
+			Height:     n.Details.Height,
+		}}
+		if p := n.Parent(); p != nil {
+			e.props.ParentID = p.ID
+		}
+		if n.IsFloor() {
+			num := n.Details.FloorNumber
+			e.props.FloorNumber = &num
+		}
+		if e.props.Width != 0 || e.props.Length != 0 || e.props.Height != 0 {
+			e.props.Unit = string(unit)
+		}
+		if t, err := transform.ForFloor(n.Details, unit); err == nil {
+			d := n.Details
+			if d.Width > 0 && d.Length > 0 {
+				// Floor y increases downwards, so this order is anticlockwise once north is up, as GeoJSON requires.
+				corners := [][2]float64{
+					{d.OffsetX, d.OffsetY},
+					{d.OffsetX, d.OffsetY + d.Length},
+					{d.OffsetX + d.Width, d.OffsetY + d.Length},
+					{d.OffsetX + d.Width, d.OffsetY},
+					{d.OffsetX, d.OffsetY},
+				}
+				for _, c := range corners {
+					lat, lon := t.ToGeo(c[0], c[1])
+					e.outline = append(e.outline, [2]float64{round(lon), round(lat)})
+				}
+			} else {
+				lat, lon := t.ToGeo(d.OffsetX, d.OffsetY)
+				e.point = &[2]float64{round(lon), round(lat)}
+			}
+		}
+		result = append(result, e)
+		return nil
+	})
+	return result
+}
+
+// elementUnit returns the unit of the element, taken from the corners of its regions, or def if they have none.
+func elementUnit(d dnas.MapItemDetails, def dnas.Unit) dnas.Unit {
+	for _, r := range d.InclusionExclusionRegion {
+		for _, c := range r.Corners {
+			if u := dnas.ParseUnit(c.Unit); u != "" {
+				return u
+			}
+		}
+	}
+	return def
+}
+
+// round rounds a longitude or latitude to 7 decimal places, about a centimetre, so that the output doesn't depend on
+// floating point differences between platforms.
+func round(v float64) float64 {
+	return math.Round(v*1e7) / 1e7
+}
@@ -0,0 +1,116 @@
+package mapexport
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"testing"
+
+	"github.com/darrenparkinson/dnas"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// testHierarchy returns a campus with a location, a building without one, a floor with gps markers and a floor without.
+func testHierarchy() *dnas.Hierarchy {
+	return dnas.NewHierarchy(dnas.MapHierarchyResponse{Map: []dnas.MapItem{{
+		ID:      "campus-1",
+		Name:    "Campus",
+		Level:   dnas.MapLevelCampus,
+		Address: "1 Main Street",
+		Details: dnas.MapItemDetails{Latitude: 51.5, Longitude: -0.12},
+		RelationshipData: dnas.MapItemRelationshipData{Children: []dnas.MapItem{{
+			ID:         "building-1",
+			ImportedID: "B1",
+			Name:       "Building",
+			Level:      dnas.MapLevelBuilding,
+			RelationshipData: dnas.MapItemRelationshipData{Children: []dnas.MapItem{
+				{
+					ID:    "floor-1",
+					Name:  "Ground",
+					Level: dnas.MapLevelFloor,
+					Details: dnas.MapItemDetails{
+						FloorNumber: 1,
+						Width:       100,
+						Length:      50,
+						Height:      10,
+						GpsMarkers: []string{
+							`{"x":0,"y":0,"latitude":51.5,"longitude":-0.12}`,
+							"100,0,51.5,-0.1186",
+						},
+					},
+				},
+				{
+					ID:      "floor-2",
+					Name:    "First",
+					Level:   dnas.MapLevelFloor,
+					Details: dnas.MapItemDetails{FloorNumber: 2, Width: 100, Length: 50},
+				},
+			}},
+		}}},
+	}}})
+}
+
+func TestWriteGeoJSON(t *testing.T) {
+	golden(t, "hierarchy.geojson", func(w io.Writer) error {
+		return WriteGeoJSON(w, testHierarchy(), Options{})
+	})
+}
+
+func TestWriteKML(t *testing.T) {
+	golden(t, "hierarchy.kml", func(w io.Writer) error {
+		return WriteKML(w, testHierarchy(), Options{})
+	})
+}
+
+// golden compares the output of write with the named file in testdata, rewriting the file if -update is given.
+func golden(t *testing.T, name string, write func(w io.Writer) error) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("%s differs from the golden file; run go test -update if the change is intended\ngot:\n%s", name, buf.Bytes())
+	}
+}
+
+func TestFloorUnit(t *testing.T) {
+	floor := func(id string, unit string) dnas.MapItem {
+		return dnas.MapItem{ID: id, Name: id, Level: dnas.MapLevelFloor, Details: dnas.MapItemDetails{
+			Width: 100, Length: 50, Latitude: 51.5, Longitude: -0.12,
+			InclusionExclusionRegion: []dnas.MapInclusionExclusionRegionItem{{
+				Type:    dnas.RegionInclusion,
+				Corners: []dnas.MapItemCorner{{Unit: unit}, {X: 100, Unit: unit}, {X: 100, Y: 50, Unit: unit}},
+			}},
+		}}
+	}
+	h := dnas.NewHierarchy(dnas.MapHierarchyResponse{Map: []dnas.MapItem{floor("feet", "FEET"), floor("meters", "METERS"), floor("default", "")}})
+	width := map[string]float64{}
+	for _, e := range elements(h, Options{Unit: dnas.UnitMeters}) {
+		width[e.props.ID] = e.outline[2][0] - e.outline[0][0]
+		want := map[string]string{"feet": "FEET", "meters": "METERS", "default": "METERS"}[e.props.ID]
+		if e.props.Unit != want {
+			t.Errorf("%s: unit = %s, want %s", e.props.ID, e.props.Unit, want)
+		}
+	}
+	if ratio := width["feet"] / width["meters"]; math.Abs(ratio-0.3048) > 1e-4 {
+		t.Errorf("feet floor is %v the width of the meters floor, want 0.3048", ratio)
+	}
+	if width["default"] != width["meters"] {
+		t.Errorf("floor without a unit is %v wide, want the default unit's %v", width["default"], width["meters"])
+	}
+}
@@ -0,0 +1,234 @@
+package dnas
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// EventType represents the type of event a subscription is notified of
+type EventType string
+
+// Fields for EventType
+const (
+	EventDeviceLocationUpdate EventType = "DEVICE_LOCATION_UPDATE"
+	EventDeviceEntry          EventType = "DEVICE_ENTRY"
+	EventDeviceExit           EventType = "DEVICE_EXIT"
+	EventAPUp                 EventType = "AP_UP"
+	EventAPDown               EventType = "AP_DOWN"
+)
+
+// SubscriptionFilterType represents the attribute a subscription filter matches on
+type SubscriptionFilterType string
+
+// Fields for SubscriptionFilterType
+const (
+	FilterByMacAddress   SubscriptionFilterType = "MAC_ADDRESS"
+	FilterByDeviceType   SubscriptionFilterType = "DEVICE_TYPE"
+	FilterBySSID         SubscriptionFilterType = "SSID"
+	FilterByManufacturer SubscriptionFilterType = "MANUFACTURER"
+	FilterByAssociated   SubscriptionFilterType = "ASSOCIATED"
+	FilterByMapElement   SubscriptionFilterType = "MAP_ELEMENT"
+)
+
+// TargetType represents how notifications are delivered
+type TargetType string
+
+// Fields for TargetType
+const (
+	TargetWebhook TargetType = "WEBHOOK"
+	TargetMQTT    TargetType = "MQTT"
+)
+
+// Subscription represents a notification subscription.
+// SubscriptionID is assigned by DNA Spaces when the subscription is created.
+type Subscription struct {
+	SubscriptionID string `json:"subscriptionId,omitempty"`
+
+	// Name of the subscription
+	Name string `json:"name"`
+
+	// EventType the subscription is notified of
+	EventType EventType `json:"eventType"`
+
+	// Enabled subscriptions send notifications
+	Enabled bool `json:"enabled"`
+
+	// Filters limit the events that are sent.  An event must match every filter.
+	Filters []SubscriptionFilter `json:"filters,omitempty"`
+
+	// Target the notifications are delivered to
+	Target SubscriptionTarget `json:"target"`
+}
+
+// SubscriptionFilter limits the events sent for a subscription to those where the attribute matches one of the values.
+// For FilterByMapElement the values are campus, building or floor identifiers.
+type SubscriptionFilter struct {
+	Type   SubscriptionFilterType `json:"type"`
+	Values []string               `json:"values"`
+}
+
+// SubscriptionTarget is where notifications for a subscription are delivered.
+type SubscriptionTarget struct {
+	Type TargetType `json:"type"`
+
+	// URI of the webhook or MQTT broker, e.g. https://example.com/dnas or mqtts://broker.example.com:8883/topic
+	URI string `json:"uri"`
+
+	// Headers are added to each webhook request, e.g. for authorization.
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// Secret is used to sign each webhook request so the receiver can verify it came from DNA Spaces.
+	Secret string `json:"secret,omitempty"`
+}
+
+// SubscriptionsResponse provides the list of subscriptions returned by ListSubscriptions
+type SubscriptionsResponse []Subscription
+
+// SubscriptionStatistics provides the delivery statistics for a subscription from GetStatistics()
+type SubscriptionStatistics struct {
+	SubscriptionID string `json:"subscriptionId"`
+
+	// The number of notifications delivered successfully.
+	Delivered int64 `json:"delivered"`
+
+	// The number of notifications that could not be delivered.
+	Failed int64 `json:"failed"`
+
+	// The message rate in 1, 5 and 15 min.
+	M1Rate  float64 `json:"m1Rate,omitempty"`
+	M5Rate  float64 `json:"m5Rate,omitempty"`
+	M15Rate float64 `json:"m15Rate,omitempty"`
+
+	// The UTC time, in milliseconds, of the last delivery attempt.
+	LastNotificationAt int64 `json:"lastNotificationAt,omitempty"`
+}
+
+// ListSubscriptions retrieves all of the notification subscriptions.
+func (s *NotificationsService) ListSubscriptions(ctx context.Context) (SubscriptionsResponse, error) {
+	sr := SubscriptionsResponse{}
+	url := fmt.Sprintf("%s/notifications", s.client.BaseURL)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return sr, err
+	}
+	if err := s.client.makeRequest(ctx, req, &sr); err != nil {
+		return sr, err
+	}
+	return sr, nil
+}
+
+// GetSubscription retrieves a notification subscription using it's identifier.
+func (s *NotificationsService) GetSubscription(ctx context.Context, id string) (Subscription, error) {
+	sub := Subscription{}
+	if id == "" {
+		return sub, errors.New("dnas: subscription id required")
+	}
+	url := fmt.Sprintf("%s/notifications/%s", s.client.BaseURL, url.PathEscape(id))
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return sub, err
+	}
+	if err := s.client.makeRequest(ctx, req, &sub); err != nil {
+		return sub, err
+	}
+	return sub, nil
+}
+
+// CreateSubscription creates a notification subscription and returns it with the identifier assigned by DNA Spaces.
+func (s *NotificationsService) CreateSubscription(ctx context.Context, sub Subscription) (Subscription, error) {
+	if err := sub.validate(); err != nil {
+		return sub, err
+	}
+	sub.SubscriptionID = ""
+	url := fmt.Sprintf("%s/notifications", s.client.BaseURL)
+	req, err := newJSONRequest("POST", url, sub)
+	if err != nil {
+		return sub, err
+	}
+	if err := s.client.makeRequest(ctx, req, &sub); err != nil {
+		return sub, err
+	}
+	return sub, nil
+}
+
+// UpdateSubscription replaces the notification subscription with the same SubscriptionID.
+func (s *NotificationsService) UpdateSubscription(ctx context.Context, sub Subscription) (Subscription, error) {
+	if sub.SubscriptionID == "" {
+		return sub, errors.New("dnas: subscription id required")
+	}
+	if err := sub.validate(); err != nil {
+		return sub, err
+	}
+	url := fmt.Sprintf("%s/notifications", s.client.BaseURL)
+	req, err := newJSONRequest("PUT", url, sub)
+	if err != nil {
+		return sub, err
+	}
+	if err := s.client.makeRequest(ctx, req, &sub); err != nil {
+		return sub, err
+	}
+	return sub, nil
+}
+
+// DeleteSubscription deletes a notification subscription using it's identifier.
+func (s *NotificationsService) DeleteSubscription(ctx context.Context, id string) error {
+	if id == "" {
+		return errors.New("dnas: subscription id required")
+	}
+	url := fmt.Sprintf("%s/notifications/%s", s.client.BaseURL, url.PathEscape(id))
+	req, err := http.NewRequest("DELETE", url, nil)
+	if err != nil {
+		return err
+	}
+	return s.client.makeRequest(ctx, req, nil)
+}
+
+// GetStatistics retrieves the delivery statistics for a notification subscription.
+func (s *NotificationsService) GetStatistics(ctx context.Context, id string) (SubscriptionStatistics, error) {
+	ss := SubscriptionStatistics{}
+	if id == "" {
+		return ss, errors.New("dnas: subscription id required")
+	}
+	url := fmt.Sprintf("%s/notifications/%s/statistics", s.client.BaseURL, url.PathEscape(id))
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return ss, err
+	}
+	if err := s.client.makeRequest(ctx, req, &ss); err != nil {
+		return ss, err
+	}
+	return ss, nil
+}
+
+// validate checks the fields DNA Spaces requires to create or update a subscription.
+func (sub Subscription) validate() error {
+	if sub.Name == "" {
+		return errors.New("dnas: subscription name required")
+	}
+	if sub.EventType == "" {
+		return errors.New("dnas: subscription event type required")
+	}
+	if sub.Target.URI == "" {
+		return errors.New("dnas: subscription target uri required")
+	}
+	return nil
+}
+
+// newJSONRequest returns a request with v encoded as the JSON body.
+func newJSONRequest(method, url string, v interface{}) (*http.Request, error) {
+	body := &bytes.Buffer{}
+	if err := json.NewEncoder(body).Encode(v); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
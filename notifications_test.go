@@ -0,0 +1,178 @@
+package dnas
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// fakeResponse is the request a fake server expects and the response it sends.
+type fakeResponse struct {
+	method string
+	path   string
+	body   interface{}
+	status int
+	reply  string
+}
+
+// newFakeServer returns a client for a server that checks each request against want and sends its response.
+func newFakeServer(t *testing.T, want fakeResponse) *Client {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != want.method {
+			t.Errorf("method = %s, want %s", r.Method, want.method)
+		}
+		if r.URL.EscapedPath() != want.path {
+			t.Errorf("path = %s, want %s", r.URL.EscapedPath(), want.path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer test-key" {
+			t.Errorf("Authorization = %q, want %q", got, "Bearer test-key")
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if want.body == nil {
+			if len(body) != 0 {
+				t.Errorf("unexpected body %s", body)
+			}
+		} else {
+			if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", ct)
+			}
+			var got, expected interface{}
+			if err := json.Unmarshal(body, &got); err != nil {
+				t.Errorf("invalid body %s: %v", body, err)
+			}
+			b, _ := json.Marshal(want.body)
+			json.Unmarshal(b, &expected)
+			if !reflect.DeepEqual(got, expected) {
+				t.Errorf("body = %s, want %s", body, b)
+			}
+		}
+		if want.reply != "" {
+			w.Header().Set("Content-Type", "application/json")
+		}
+		w.WriteHeader(want.status)
+		w.Write([]byte(want.reply))
+	}))
+	t.Cleanup(srv.Close)
+	c, err := NewClient("test-key", "io", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.BaseURL = srv.URL
+	return c
+}
+
+func testSubscription() Subscription {
+	return Subscription{
+		Name:      "entries",
+		EventType: EventDeviceEntry,
+		Enabled:   true,
+		Filters:   []SubscriptionFilter{{Type: FilterByMapElement, Values: []string{"floor-1"}}},
+		Target:    SubscriptionTarget{Type: TargetWebhook, URI: "https://example.com/dnas", Secret: "s3cret"},
+	}
+}
+
+func TestListSubscriptions(t *testing.T) {
+	c := newFakeServer(t, fakeResponse{method: "GET", path: "/notifications", status: 200,
+		reply: `[{"subscriptionId":"a","name":"one","eventType":"AP_UP"},{"subscriptionId":"b","name":"two","eventType":"AP_DOWN"}]`})
+	subs, err := c.NotificationsService.ListSubscriptions(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subs) != 2 || subs[0].SubscriptionID != "a" || subs[1].EventType != EventAPDown {
+		t.Errorf("unexpected subscriptions %+v", subs)
+	}
+}
+
+func TestGetSubscription(t *testing.T) {
+	c := newFakeServer(t, fakeResponse{method: "GET", path: "/notifications/a%2Fb", status: 200,
+		reply: `{"subscriptionId":"a/b","name":"one","eventType":"DEVICE_ENTRY","enabled":true}`})
+	sub, err := c.NotificationsService.GetSubscription(context.Background(), "a/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub.SubscriptionID != "a/b" || !sub.Enabled || sub.EventType != EventDeviceEntry {
+		t.Errorf("unexpected subscription %+v", sub)
+	}
+}
+
+func TestCreateSubscription(t *testing.T) {
+	sub := testSubscription()
+	c := newFakeServer(t, fakeResponse{method: "POST", path: "/notifications", body: sub, status: 201,
+		reply: `{"subscriptionId":"new","name":"entries","eventType":"DEVICE_ENTRY","enabled":true}`})
+	sub.SubscriptionID = "ignored"
+	created, err := c.NotificationsService.CreateSubscription(context.Background(), sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.SubscriptionID != "new" {
+		t.Errorf("SubscriptionID = %q, want new", created.SubscriptionID)
+	}
+}
+
+func TestCreateSubscriptionEmptyBody(t *testing.T) {
+	sub := testSubscription()
+	c := newFakeServer(t, fakeResponse{method: "POST", path: "/notifications", body: sub, status: 201})
+	created, err := c.NotificationsService.CreateSubscription(context.Background(), sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(created, sub) {
+		t.Errorf("created = %+v, want %+v", created, sub)
+	}
+}
+
+func TestUpdateSubscriptionNoContent(t *testing.T) {
+	sub := testSubscription()
+	sub.SubscriptionID = "a"
+	c := newFakeServer(t, fakeResponse{method: "PUT", path: "/notifications", body: sub, status: 204})
+	updated, err := c.NotificationsService.UpdateSubscription(context.Background(), sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(updated, sub) {
+		t.Errorf("updated = %+v, want %+v", updated, sub)
+	}
+}
+
+func TestDeleteSubscription(t *testing.T) {
+	c := newFakeServer(t, fakeResponse{method: "DELETE", path: "/notifications/a", status: 200, reply: `{"success":true}`})
+	if err := c.NotificationsService.DeleteSubscription(context.Background(), "a"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetStatistics(t *testing.T) {
+	c := newFakeServer(t, fakeResponse{method: "GET", path: "/notifications/a/statistics", status: 200,
+		reply: `{"subscriptionId":"a","delivered":10,"failed":2,"m1Rate":0.5}`})
+	ss, err := c.NotificationsService.GetStatistics(context.Background(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := SubscriptionStatistics{SubscriptionID: "a", Delivered: 10, Failed: 2, M1Rate: 0.5}
+	if ss != want {
+		t.Errorf("statistics = %+v, want %+v", ss, want)
+	}
+}
+
+func TestSubscriptionErrors(t *testing.T) {
+	c := newFakeServer(t, fakeResponse{method: "GET", path: "/notifications/a", status: 403, reply: `{"message":"nope"}`})
+	if _, err := c.NotificationsService.GetSubscription(context.Background(), "a"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("err = %v, want ErrForbidden", err)
+	}
+	if _, err := c.NotificationsService.CreateSubscription(context.Background(), Subscription{}); err == nil {
+		t.Error("expected validation error for empty subscription")
+	}
+	if err := c.NotificationsService.DeleteSubscription(context.Background(), ""); err == nil {
+		t.Error("expected error for empty id")
+	}
+}
@@ -0,0 +1,188 @@
+package dnas
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultOccupancyInterval is the bucket size used by an OccupancyAggregator when none is given.
+const DefaultOccupancyInterval = time.Hour
+
+// OccupancyAggregator counts unique devices per floor, building and campus over fixed time intervals.
+type OccupancyAggregator struct {
+	// Interval is the size of each time bucket.  If zero, DefaultOccupancyInterval is used.
+	Interval time.Duration
+
+	// DeviceTypes restricts the count to the given device types, e.g. CLIENT or TAG.  If empty, all device types are counted.
+	DeviceTypes []string
+
+	// AssociatedOnly restricts the count to associated devices.
+	AssociatedOnly bool
+
+	// Static restricts the count to static devices when true, or excludes them when false.  If nil, both are counted.
+	Static *bool
+}
+
+// OccupancyPoint is the number of unique devices seen in a single interval.
+type OccupancyPoint struct {
+	Start time.Time `json:"start"`
+	Count int       `json:"count"`
+}
+
+// OccupancySeries is the occupancy time series for a single map element.
+type OccupancySeries struct {
+	Level  string           `json:"level"`
+	ID     string           `json:"id"`
+	Name   string           `json:"name,omitempty"`
+	Points []OccupancyPoint `json:"points"`
+}
+
+// OccupancyReport contains the occupancy time series for every map element seen in the history records.
+// Every series has a point for every interval between Start and End, including those with no devices.
+type OccupancyReport struct {
+	Interval time.Duration     `json:"interval"`
+	Start    time.Time         `json:"start"`
+	End      time.Time         `json:"end"`
+	Series   []OccupancySeries `json:"series"`
+
+	// Skipped is the number of history records that were skipped because their timestamp couldn't be parsed.
+	Skipped int `json:"skipped"`
+}
+
+// NewOccupancyAggregator returns an OccupancyAggregator using the given interval and no filters.
+func NewOccupancyAggregator(interval time.Duration) *OccupancyAggregator {
+	return &OccupancyAggregator{Interval: interval}
+}
+
+// occupancyKey identifies a single map element.
+type occupancyKey struct {
+	level string
+	id    string
+}
+
+// Aggregate buckets the history records and counts unique devices per floor, building and campus.
+// If hierarchy is given, it is used to resolve the names of the map elements.
+// Records with a timestamp that can't be parsed are skipped and counted in the report's Skipped.
+func (a *OccupancyAggregator) Aggregate(items []HistoryItem, hierarchy *MapHierarchyResponse) OccupancyReport {
+	interval := a.Interval
+	if interval <= 0 {
+		interval = DefaultOccupancyInterval
+	}
+	report := OccupancyReport{Interval: interval}
+
+	// devices holds the set of unique mac addresses per map element per bucket.
+	devices := make(map[occupancyKey]map[int64]map[string]bool)
+	var first, last int64
+	seen := false
+	for _, item := range items {
+		if !a.include(item) {
+			continue
+		}
+		ts, err := item.Timestamp()
+		if err != nil {
+			report.Skipped++
+			continue
+		}
+		bucket := ts.Truncate(interval).UnixNano()
+		if !seen || bucket < first {
+			first = bucket
+		}
+		if !seen || bucket > last {
+			last = bucket
+		}
+		seen = true
+		for _, k := range []occupancyKey{
+			{MapLevelCampus, item.CampusID},
+			{MapLevelBuilding, item.BuildingID},
+			{MapLevelFloor, item.FloorID},
+		} {
+			if k.id == "" {
+				continue
+			}
+			if devices[k] == nil {
+				devices[k] = make(map[int64]map[string]bool)
+			}
+			if devices[k][bucket] == nil {
+				devices[k][bucket] = make(map[string]bool)
+			}
+			devices[k][bucket][item.MacAddress] = true
+		}
+	}
+	if !seen {
+		return report
+	}
+	report.Start = time.Unix(0, first)
+	report.End = time.Unix(0, last).Add(interval)
+
+	var h *Hierarchy
+	if hierarchy != nil {
+		h = NewHierarchy(*hierarchy)
+	}
+	for k, buckets := range devices {
+		series := OccupancySeries{Level: k.level, ID: k.id}
+		if h != nil {
+			if n, ok := h.ByID(k.id); ok {
+				series.Name = n.Name
+			}
+		}
+		for b := first; b <= last; b += int64(interval) {
+			series.Points = append(series.Points, OccupancyPoint{Start: time.Unix(0, b), Count: len(buckets[b])})
+		}
+		report.Series = append(report.Series, series)
+	}
+	levelOrder := map[string]int{MapLevelCampus: 0, MapLevelBuilding: 1, MapLevelFloor: 2}
+	sort.Slice(report.Series, func(i, j int) bool {
+		si, sj := report.Series[i], report.Series[j]
+		if si.Level != sj.Level {
+			return levelOrder[si.Level] < levelOrder[sj.Level]
+		}
+		return si.ID < sj.ID
+	})
+	return report
+}
+
+// include reports whether the history item passes the aggregator filters.
+func (a *OccupancyAggregator) include(item HistoryItem) bool {
+	if a.AssociatedOnly && !item.IsAssociated() {
+		return false
+	}
+	if a.Static != nil && *a.Static != item.IsStatic() {
+		return false
+	}
+	if len(a.DeviceTypes) == 0 {
+		return true
+	}
+	for _, t := range a.DeviceTypes {
+		if strings.EqualFold(t, item.DeviceType) {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteCSV writes the report as CSV with one row per map element per interval.
+func (r OccupancyReport) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"level", "id", "name", "start", "count"}); err != nil {
+		return err
+	}
+	for _, s := range r.Series {
+		for _, p := range s.Points {
+			if err := cw.Write([]string{s.Level, s.ID, s.Name, p.Start.UTC().Format(time.RFC3339), strconv.Itoa(p.Count)}); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteJSON writes the report as JSON.
+func (r OccupancyReport) WriteJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(r)
+}
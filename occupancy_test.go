@@ -0,0 +1,133 @@
+package dnas
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// occupancyItem returns a history record of a client on f1 in b1 on c1 at the given time after day0.
+func occupancyItem(mac string, d time.Duration) HistoryItem {
+	return HistoryItem{
+		MacAddress:      mac,
+		SourceTimestamp: fmt.Sprint(timeToMillis(day0.Add(d))),
+		CampusID:        "c1",
+		BuildingID:      "b1",
+		FloorID:         "f1",
+		DeviceType:      "CLIENT",
+		Associated:      "true",
+		StaticDevice:    "false",
+	}
+}
+
+// occupancyCounts returns the counts of each series in the report.
+func occupancyCounts(r OccupancyReport) string {
+	var s []string
+	for _, series := range r.Series {
+		var counts []string
+		for _, p := range series.Points {
+			counts = append(counts, fmt.Sprint(p.Count))
+		}
+		s = append(s, series.ID+":"+strings.Join(counts, ","))
+	}
+	return strings.Join(s, " ")
+}
+
+func TestOccupancyAggregate(t *testing.T) {
+	f2 := occupancyItem("b", 30*time.Minute)
+	f2.FloorID = "f2"
+	items := []HistoryItem{
+		occupancyItem("a", 0),
+		occupancyItem("a", 10*time.Minute),
+		f2,
+		{MacAddress: "c", SourceTimestamp: "yesterday", CampusID: "c1"},
+		// The hour in between has no devices.
+		occupancyItem("a", 2*time.Hour+time.Minute),
+	}
+	hierarchy := testHierarchy()
+	report := NewOccupancyAggregator(0).Aggregate(items, &hierarchy)
+	if report.Skipped != 1 || report.Interval != time.Hour {
+		t.Errorf("skipped %d with interval %v, want 1 and an hour", report.Skipped, report.Interval)
+	}
+	if !report.Start.Equal(day0) || !report.End.Equal(day0.Add(3*time.Hour)) {
+		t.Errorf("report from %v to %v", report.Start, report.End)
+	}
+	if got := occupancyCounts(report); got != "c1:2,0,1 b1:2,0,1 f1:1,0,1 f2:1,0,0" {
+		t.Errorf("counts %q", got)
+	}
+	if s := report.Series[0]; s.Level != MapLevelCampus || s.Name != "Campus" {
+		t.Errorf("series %+v, want the campus name", s)
+	}
+
+	if report := NewOccupancyAggregator(0).Aggregate(items[3:4], nil); len(report.Series) != 0 || report.Skipped != 1 {
+		t.Errorf("report %+v, want no series", report)
+	}
+}
+
+func TestOccupancyFilters(t *testing.T) {
+	tag := occupancyItem("tag", 0)
+	tag.DeviceType = "TAG"
+	unassociated := occupancyItem("unassociated", 0)
+	unassociated.Associated = "false"
+	static := occupancyItem("static", 0)
+	static.StaticDevice = "true"
+	items := []HistoryItem{occupancyItem("a", 0), tag, unassociated, static}
+
+	yes, no := true, false
+	tests := []struct {
+		agg  OccupancyAggregator
+		want string
+	}{
+		{OccupancyAggregator{}, "4"},
+		{OccupancyAggregator{DeviceTypes: []string{"client"}}, "3"},
+		{OccupancyAggregator{DeviceTypes: []string{"TAG", "CLIENT"}}, "4"},
+		{OccupancyAggregator{AssociatedOnly: true}, "3"},
+		{OccupancyAggregator{Static: &yes}, "1"},
+		{OccupancyAggregator{Static: &no}, "3"},
+		{OccupancyAggregator{DeviceTypes: []string{"CLIENT"}, AssociatedOnly: true, Static: &no}, "1"},
+	}
+	for _, tt := range tests {
+		report := tt.agg.Aggregate(items, nil)
+		if got := occupancyCounts(report); got != "c1:"+tt.want+" b1:"+tt.want+" f1:"+tt.want {
+			t.Errorf("%+v counts %q, want %s", tt.agg, got, tt.want)
+		}
+	}
+}
+
+func TestOccupancyWrite(t *testing.T) {
+	items := []HistoryItem{occupancyItem("a", 0), occupancyItem("b", 45*time.Minute)}
+	hierarchy := testHierarchy()
+	report := NewOccupancyAggregator(30*time.Minute).Aggregate(items, &hierarchy)
+
+	var buf bytes.Buffer
+	if err := report.WriteCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := `level,id,name,start,count
+CAMPUS,c1,Campus,2021-03-01T00:00:00Z,1
+CAMPUS,c1,Campus,2021-03-01T00:30:00Z,1
+BUILDING,b1,Building,2021-03-01T00:00:00Z,1
+BUILDING,b1,Building,2021-03-01T00:30:00Z,1
+FLOOR,f1,Ground,2021-03-01T00:00:00Z,1
+FLOOR,f1,Ground,2021-03-01T00:30:00Z,1
+`
+	if buf.String() != want {
+		t.Errorf("csv:\n%s\nwant:\n%s", buf.String(), want)
+	}
+
+	buf.Reset()
+	if err := report.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var decoded OccupancyReport
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Interval != 30*time.Minute || len(decoded.Series) != 3 || occupancyCounts(decoded) != occupancyCounts(report) ||
+		!decoded.Start.Equal(day0) {
+		t.Errorf("decoded %+v", decoded)
+	}
+}
@@ -0,0 +1,129 @@
+package dnas
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// earthRadius is the mean radius of the earth in meters.
+const earthRadius = 6371008.8
+
+// Point is a location on a floor.
+// DNA Spaces provides coordinates in the floor's unit, so points returned by the accessors on LocationDevice,
+// HistoryItem and HistoryClientsDevice have no Unit.  Use WithUnit, e.g. with the floor's MapItemDetails.Unit(),
+// before converting or comparing points from floors drawn in different units.
+type Point struct {
+	X    float64 `json:"x"`
+	Y    float64 `json:"y"`
+	Unit Unit    `json:"unit,omitempty"`
+}
+
+// GeoPoint is a geographic location in degrees.
+type GeoPoint struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// WithUnit returns the point with its unit set, without converting the coordinates.
+func (p Point) WithUnit(unit Unit) Point {
+	p.Unit = unit
+	return p
+}
+
+// In returns the point converted to the given unit.
+// If the point has no unit it is assumed to already be in that unit.
+func (p Point) In(unit Unit) Point {
+	if p.Unit == "" {
+		return p.WithUnit(unit)
+	}
+	return Point{X: p.Unit.Convert(p.X, unit), Y: p.Unit.Convert(p.Y, unit), Unit: unit}
+}
+
+// Distance returns the distance between the points in the unit of p.
+// If both points have a unit, q is converted to the unit of p first.
+func (p Point) Distance(q Point) float64 {
+	if p.Unit != "" && q.Unit != "" {
+		q = q.In(p.Unit)
+	}
+	return math.Hypot(p.X-q.X, p.Y-q.Y)
+}
+
+// Distance returns the great circle distance between the points in meters.
+func (g GeoPoint) Distance(o GeoPoint) float64 {
+	rad := math.Pi / 180
+	lat1, lat2 := g.Latitude*rad, o.Latitude*rad
+	dLat := lat2 - lat1
+	dLon := (o.Longitude - g.Longitude) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// Unit returns the unit the floor is drawn in, taken from the corners of its inclusion and exclusion regions.
+// DNA Spaces defaults to feet, so UnitFeet is returned if the regions do not specify a unit.
+func (d MapItemDetails) Unit() Unit {
+	for _, r := range d.InclusionExclusionRegion {
+		for _, c := range r.Corners {
+			if u := ParseUnit(c.Unit); u != "" {
+				return u
+			}
+		}
+	}
+	return UnitFeet
+}
+
+// FloorPoint returns the point in the unit of the floor.
+func (d MapItemDetails) FloorPoint(x, y float64) Point {
+	return Point{X: x, Y: y, Unit: d.Unit()}
+}
+
+// Point returns the x and y coordinates of the device, and false if the device has no coordinates.
+func (d LocationDevice) Point() (Point, bool) {
+	return pointFrom(d.Coordinates)
+}
+
+// RawPoint returns the raw x and y coordinates of the device, and false if the device has no raw coordinates.
+func (d LocationDevice) RawPoint() (Point, bool) {
+	return pointFrom(d.RawCoordinates)
+}
+
+// GeoPoint returns the geographic coordinates of the device, and false if the device has none.
+// DNA Spaces provides these as latitude followed by longitude.
+func (d LocationDevice) GeoPoint() (GeoPoint, bool) {
+	if len(d.GeoCoordinates) < 2 {
+		return GeoPoint{}, false
+	}
+	return GeoPoint{Latitude: d.GeoCoordinates[0], Longitude: d.GeoCoordinates[1]}, true
+}
+
+// Point returns the coordinates of the location, and false if it has no coordinates.
+func (h HistoryClientsDevice) Point() (Point, bool) {
+	return pointFrom(h.Coordinates)
+}
+
+// Timestamp returns the SourceTimestamp of the location as a time.Time.
+func (h HistoryClientsDevice) Timestamp() time.Time {
+	return millisToTime(h.SourceTimestamp)
+}
+
+// Point returns the coordinates of the history item.
+func (h HistoryItem) Point() (Point, error) {
+	x, err := strconv.ParseFloat(h.CoordinateX, 64)
+	if err != nil {
+		return Point{}, fmt.Errorf("dnas: invalid x coordinate %q: %w", h.CoordinateX, err)
+	}
+	y, err := strconv.ParseFloat(h.CoordinateY, 64)
+	if err != nil {
+		return Point{}, fmt.Errorf("dnas: invalid y coordinate %q: %w", h.CoordinateY, err)
+	}
+	return Point{X: x, Y: y}, nil
+}
+
+// pointFrom returns a Point from a coordinates slice.
+func pointFrom(coords []float64) (Point, bool) {
+	if len(coords) < 2 {
+		return Point{}, false
+	}
+	return Point{X: coords[0], Y: coords[1]}, true
+}
@@ -0,0 +1,368 @@
+package dnas
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultProximityMaxGap is the time tolerance used to match observations of two devices when none is given.
+const DefaultProximityMaxGap = time.Minute
+
+// DefaultProximityMaxRequests is the maximum number of API requests made by Proximity when none is given.
+const DefaultProximityMaxRequests = 500
+
+// DefaultProximityConcurrency is the number of API requests Proximity makes at once when none is given.
+const DefaultProximityConcurrency = 4
+
+// ProximityParameters represent the options for Proximity()
+type ProximityParameters struct {
+	// DeviceID is the device to find contacts for, as used with GetClient.  Hashed mac addresses may be used.
+	DeviceID string
+
+	// StartTime and EndTime give the window to search.  The history API limits this to at most 1 day.
+	StartTime time.Time
+	EndTime   time.Time
+
+	// Radius is the contact distance in Unit.
+	Radius float64
+
+	// Unit of Radius, and of the closest distance of each contact.  If empty, they are in FloorUnit.
+	Unit Unit
+
+	// FloorUnit is the unit the floors are drawn in, as given by MapItemDetails.Unit(), which Radius is converted to.
+	// If empty, UnitFeet, the DNA Spaces default, is assumed, so it should be set for floors drawn in meters.
+	FloorUnit Unit
+
+	// MaxGap is the maximum time between an observation of the device and an observation of another device
+	// for them to be considered at the same place at the same time.  If zero, DefaultProximityMaxGap is used.
+	MaxGap time.Duration
+
+	// MaxRequests limits the number of API requests made.  If zero, DefaultProximityMaxRequests is used.
+	MaxRequests int
+
+	// Concurrency is the number of API requests made at once.  If zero, DefaultProximityConcurrency is used.
+	Concurrency int
+}
+
+// Contact represents a device that was within the radius of the target device.
+type Contact struct {
+	// MacAddress of the contact, exactly as returned by DNA Spaces.  Hashed mac addresses are left hashed.
+	MacAddress string `json:"macAddress"`
+
+	// Exposure is the cumulative time the contact was within the radius.
+	Exposure time.Duration `json:"exposure"`
+
+	// ClosestDistance is the smallest distance seen between the devices, in the unit of the radius.
+	ClosestDistance float64 `json:"closestDistance"`
+
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+
+	// Floors the devices were in contact on.
+	Floors []string `json:"floors"`
+}
+
+// ProximityReport is the result of Proximity()
+type ProximityReport struct {
+	DeviceID  string    `json:"deviceId"`
+	StartTime time.Time `json:"startTime"`
+	EndTime   time.Time `json:"endTime"`
+	Radius    float64   `json:"radius"`
+	Unit      Unit      `json:"unit,omitempty"`
+
+	// PathPoints is the number of observations of the target device in the window.
+	PathPoints int `json:"pathPoints"`
+
+	// Contacts are ordered by exposure, longest first.
+	Contacts []Contact `json:"contacts"`
+
+	// Truncated is true if MaxRequests was reached, so parts of the path weren't searched or some devices found
+	// weren't compared.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// Proximity finds the devices that were within the radius of the given device on the same floor during the window.
+// The path of the device is retrieved using GetClient.  Other devices near each point on the path are found using ListClients,
+// and their own paths are then compared against the device to calculate exposure time and closest distance.
+//
+// This makes a number of API requests proportional to the length of the path and the number of devices found, up to
+// MaxRequests.  If the limit is reached, the path is searched from the start and the devices found near the most
+// points are compared first, and the report is marked as Truncated.  If any request fails, the rest are cancelled and
+// the error is returned.
+func (s *HistoryService) Proximity(ctx context.Context, p ProximityParameters) (ProximityReport, error) {
+	report := ProximityReport{DeviceID: p.DeviceID, StartTime: p.StartTime, EndTime: p.EndTime, Radius: p.Radius, Unit: p.Unit}
+	if p.DeviceID == "" {
+		return report, errors.New("dnas: device id required")
+	}
+	if p.Radius <= 0 {
+		return report, errors.New("dnas: radius must be greater than zero")
+	}
+	if p.StartTime.IsZero() || p.EndTime.IsZero() {
+		return report, errors.New("dnas: start and end time required")
+	}
+	if !p.EndTime.After(p.StartTime) {
+		return report, errors.New("dnas: end time must be after start time")
+	}
+	maxGap := p.MaxGap
+	if maxGap <= 0 {
+		maxGap = DefaultProximityMaxGap
+	}
+	maxRequests := p.MaxRequests
+	if maxRequests <= 0 {
+		maxRequests = DefaultProximityMaxRequests
+	}
+	concurrency := p.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultProximityConcurrency
+	}
+	floorUnit := p.FloorUnit
+	if floorUnit == "" {
+		floorUnit = UnitFeet
+	}
+	radius := p.Radius
+	if p.Unit != "" {
+		radius = p.Unit.Convert(p.Radius, floorUnit)
+	}
+
+	window := &HistoryClientsParameters{
+		StartTime: String(strconv.FormatInt(timeToMillis(p.StartTime), 10)),
+		EndTime:   String(strconv.FormatInt(timeToMillis(p.EndTime), 10)),
+	}
+	path, err := s.GetClient(ctx, p.DeviceID, window)
+	if err != nil {
+		return report, err
+	}
+	report.PathPoints = len(path)
+	budget := maxRequests - 1
+
+	// Find the points to search around.  Points close in both place and time to one already chosen are skipped
+	// since the search around that point will already have found the same devices.  The search only covers maxGap
+	// either side of the point, so a device that stays still is searched for again after that.
+	var searches []*HistoryClientsParameters
+	var lastFloor string
+	var last Point
+	var lastTime time.Time
+	for _, pt := range path {
+		point, ok := pt.Point()
+		if !ok {
+			continue
+		}
+		ts := pt.Timestamp()
+		if !lastTime.IsZero() && pt.FloorID == lastFloor && point.Distance(last) < radius/2 && ts.Sub(lastTime) <= maxGap {
+			continue
+		}
+		lastFloor, last, lastTime = pt.FloorID, point, ts
+		searches = append(searches, &HistoryClientsParameters{
+			FloorID:   String(pt.FloorID),
+			X:         Float64(point.X),
+			Y:         Float64(point.Y),
+			Radius:    Float64(radius),
+			StartTime: String(strconv.FormatInt(timeToMillis(ts.Add(-maxGap)), 10)),
+			EndTime:   String(strconv.FormatInt(timeToMillis(ts.Add(maxGap)), 10)),
+		})
+	}
+	if len(searches) > budget {
+		searches = searches[:budget]
+		report.Truncated = true
+	}
+	budget -= len(searches)
+
+	found := make([]HistoryClientsResponse, len(searches))
+	err = forEachLimited(ctx, len(searches), concurrency, func(ctx context.Context, i int) error {
+		near, err := s.ListClients(ctx, searches[i])
+		found[i] = near
+		return err
+	})
+	if err != nil {
+		return report, err
+	}
+
+	// Candidates found near the most points are compared first.
+	hits := make(map[string]int)
+	for _, near := range found {
+		for _, c := range near {
+			if c.MacAddress != p.DeviceID {
+				hits[c.MacAddress]++
+			}
+		}
+	}
+	candidates := make([]string, 0, len(hits))
+	for mac := range hits {
+		candidates = append(candidates, mac)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if hits[candidates[i]] != hits[candidates[j]] {
+			return hits[candidates[i]] > hits[candidates[j]]
+		}
+		return candidates[i] < candidates[j]
+	})
+	if len(candidates) > budget {
+		candidates = candidates[:budget]
+		report.Truncated = true
+	}
+
+	paths := make([]HistoryClientsDeviceResponse, len(candidates))
+	err = forEachLimited(ctx, len(candidates), concurrency, func(ctx context.Context, i int) error {
+		h, err := s.GetClient(ctx, candidates[i], window)
+		paths[i] = h
+		return err
+	})
+	if err != nil {
+		return report, err
+	}
+	others := make(map[string]HistoryClientsDeviceResponse, len(candidates))
+	for i, mac := range candidates {
+		others[mac] = paths[i]
+	}
+	report.Contacts = ComputeContacts(path, others, radius, maxGap)
+	if p.Unit != "" {
+		for i := range report.Contacts {
+			report.Contacts[i].ClosestDistance = floorUnit.Convert(report.Contacts[i].ClosestDistance, p.Unit)
+		}
+	}
+	return report, nil
+}
+
+// ComputeContacts compares the path of a target device against the paths of other devices keyed by mac address.
+// An observation of another device is in contact when the nearest observation of the target in time is within maxGap,
+// on the same floor and within the radius.  Exposure is accumulated between consecutive observations in contact,
+// with each step limited to maxGap.  Only devices with at least one observation in contact are returned.
+func ComputeContacts(target HistoryClientsDeviceResponse, others map[string]HistoryClientsDeviceResponse, radius float64, maxGap time.Duration) []Contact {
+	if maxGap <= 0 {
+		maxGap = DefaultProximityMaxGap
+	}
+	path := make(HistoryClientsDeviceResponse, 0, len(target))
+	for _, pt := range target {
+		if len(pt.Coordinates) >= 2 {
+			path = append(path, pt)
+		}
+	}
+	sort.Slice(path, func(i, j int) bool { return path[i].SourceTimestamp < path[j].SourceTimestamp })
+	gapMs := int64(maxGap / time.Millisecond)
+
+	var contacts []Contact
+	for mac, h := range others {
+		obs := make(HistoryClientsDeviceResponse, len(h))
+		copy(obs, h)
+		sort.Slice(obs, func(i, j int) bool { return obs[i].SourceTimestamp < obs[j].SourceTimestamp })
+
+		c := Contact{MacAddress: mac, ClosestDistance: math.Inf(1)}
+		floors := make(map[string]bool)
+		var prev int64
+		inContact := false
+		for _, o := range obs {
+			if len(o.Coordinates) < 2 {
+				inContact = false
+				continue
+			}
+			// Find the nearest observation of the target in time.
+			i := sort.Search(len(path), func(i int) bool { return path[i].SourceTimestamp >= o.SourceTimestamp })
+			best := -1
+			for _, j := range []int{i - 1, i} {
+				if j < 0 || j >= len(path) {
+					continue
+				}
+				if best == -1 || absInt64(path[j].SourceTimestamp-o.SourceTimestamp) < absInt64(path[best].SourceTimestamp-o.SourceTimestamp) {
+					best = j
+				}
+			}
+			if best == -1 || absInt64(path[best].SourceTimestamp-o.SourceTimestamp) > gapMs || path[best].FloorID != o.FloorID {
+				inContact = false
+				continue
+			}
+			op, _ := o.Point()
+			tp, _ := path[best].Point()
+			d := op.Distance(tp)
+			if d > radius {
+				inContact = false
+				continue
+			}
+			ts := o.Timestamp()
+			if c.FirstSeen.IsZero() {
+				c.FirstSeen = ts
+			}
+			c.LastSeen = ts
+			if d < c.ClosestDistance {
+				c.ClosestDistance = d
+			}
+			if !floors[o.FloorID] {
+				floors[o.FloorID] = true
+				c.Floors = append(c.Floors, o.FloorID)
+			}
+			if inContact {
+				step := o.SourceTimestamp - prev
+				if step > gapMs {
+					step = gapMs
+				}
+				c.Exposure += time.Duration(step) * time.Millisecond
+			}
+			inContact = true
+			prev = o.SourceTimestamp
+		}
+		if !c.FirstSeen.IsZero() {
+			contacts = append(contacts, c)
+		}
+	}
+	sort.Slice(contacts, func(i, j int) bool {
+		if contacts[i].Exposure != contacts[j].Exposure {
+			return contacts[i].Exposure > contacts[j].Exposure
+		}
+		return contacts[i].MacAddress < contacts[j].MacAddress
+	})
+	return contacts
+}
+
+// forEachLimited calls fn for each index from 0 to n-1, running up to workers calls at once.  The context passed to fn
+// is cancelled on the first error, which is returned once the running calls have finished.
+func forEachLimited(ctx context.Context, n, workers int, fn func(ctx context.Context, i int) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	indexes := make(chan int)
+	var mu sync.Mutex
+	var firstErr error
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if ctx.Err() != nil {
+					continue
+				}
+				if err := fn(ctx, i); err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+						cancel()
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for i := 0; i < n && ctx.Err() == nil; i++ {
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+		}
+	}
+	close(indexes)
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// absInt64 returns the absolute value of v.
+func absInt64(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
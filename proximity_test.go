@@ -0,0 +1,232 @@
+package dnas
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// devicePath returns observations on a floor, one a minute from day0, at the given coordinates.
+func devicePath(floor string, coords ...[2]float64) HistoryClientsDeviceResponse {
+	var h HistoryClientsDeviceResponse
+	for i, c := range coords {
+		h = append(h, HistoryClientsDevice{
+			FloorID:         floor,
+			SourceTimestamp: timeToMillis(day0.Add(time.Duration(i) * time.Minute)),
+			Coordinates:     []float64{c[0], c[1]},
+		})
+	}
+	return h
+}
+
+func TestComputeContacts(t *testing.T) {
+	target := devicePath("f1", [2]float64{0, 0}, [2]float64{0, 0}, [2]float64{0, 0}, [2]float64{0, 0}, [2]float64{100, 0})
+	others := map[string]HistoryClientsDeviceResponse{
+		"near":        devicePath("f1", [2]float64{3, 4}, [2]float64{1, 1}, [2]float64{6, 8}, [2]float64{3, 4}, [2]float64{3, 4}),
+		"far":         devicePath("f1", [2]float64{30, 40}, [2]float64{30, 40}),
+		"other floor": devicePath("f2", [2]float64{0, 0}, [2]float64{0, 0}),
+		"brief":       devicePath("f1", [2]float64{100, 1}, [2]float64{100, 1}, [2]float64{100, 1}, [2]float64{100, 1}, [2]float64{100, 1}),
+	}
+	contacts := ComputeContacts(target, others, 5, 0)
+	if len(contacts) != 2 {
+		t.Fatalf("contacts %+v, want near and brief", contacts)
+	}
+	near := contacts[0]
+	// In contact at 0, 1 and 3 minutes, so exposure is the minute between the first two only.
+	if near.MacAddress != "near" || near.Exposure != time.Minute || near.ClosestDistance != math.Sqrt2 ||
+		!near.FirstSeen.Equal(day0) || !near.LastSeen.Equal(day0.Add(3*time.Minute)) || len(near.Floors) != 1 {
+		t.Errorf("near = %+v", near)
+	}
+	// The nearest observation of the target in time is at (0, 0) until the last minute.
+	if brief := contacts[1]; brief.MacAddress != "brief" || brief.Exposure != 0 || brief.ClosestDistance != 1 {
+		t.Errorf("brief = %+v", brief)
+	}
+}
+
+// proximityServer serves the history of the devices in paths, and lists the devices in near for every search.
+type proximityServer struct {
+	paths map[string]HistoryClientsDeviceResponse
+	near  []string
+	fail  string
+
+	mu       sync.Mutex
+	searches []string
+	fetched  []string
+}
+
+func (p *proximityServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if r.URL.Path == "/history/clients" {
+		p.searches = append(p.searches, r.URL.Query().Get("x")+","+r.URL.Query().Get("radius"))
+		var res HistoryClientsResponse
+		for _, mac := range p.near {
+			res = append(res, struct {
+				MacAddress string `json:"macAddress,omitempty"`
+			}{mac})
+		}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	mac := strings.TrimPrefix(r.URL.Path, "/history/clients/")
+	p.fetched = append(p.fetched, mac)
+	if mac == p.fail {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(p.paths[mac])
+}
+
+// requests returns the x and radius of each search and the devices fetched, and resets them.
+func (p *proximityServer) requests() (searches, fetched []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	searches, fetched = p.searches, p.fetched
+	p.searches, p.fetched = nil, nil
+	return searches, fetched
+}
+
+func newProximityClient(t *testing.T, p *proximityServer) *Client {
+	srv := httptest.NewServer(p)
+	t.Cleanup(srv.Close)
+	c, err := NewClient("test-key", "io", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.BaseURL = srv.URL
+	return c
+}
+
+func proximityParams() ProximityParameters {
+	return ProximityParameters{DeviceID: "target", StartTime: day0, EndTime: day0.Add(time.Hour), Radius: 2, Unit: UnitMeters}
+}
+
+func TestProximity(t *testing.T) {
+	p := &proximityServer{
+		paths: map[string]HistoryClientsDeviceResponse{
+			"target": devicePath("f1", [2]float64{0, 0}, [2]float64{1, 0}, [2]float64{50, 0}),
+			"b":      devicePath("f1", [2]float64{0, 3.2808}, [2]float64{1, 3.2808}),
+		},
+		near: []string{"target", "b", "c"},
+	}
+	c := newProximityClient(t, p)
+	report, err := c.HistoryService.Proximity(context.Background(), proximityParams())
+	if err != nil {
+		t.Fatal(err)
+	}
+	searches, fetched := p.requests()
+	// The second point is close to the first, so only two searches are made, with the radius in feet.
+	if strings.Join(searches, " ") != "0,6.561679790026246 50,6.561679790026246" {
+		t.Errorf("searches %q", searches)
+	}
+	if len(fetched) != 3 || report.PathPoints != 3 || report.Truncated {
+		t.Errorf("fetched %q with %d path points, truncated %v", fetched, report.PathPoints, report.Truncated)
+	}
+	if len(report.Contacts) != 1 || report.Contacts[0].MacAddress != "b" || report.Contacts[0].Exposure != time.Minute ||
+		math.Abs(report.Contacts[0].ClosestDistance-1) > 1e-4 {
+		t.Errorf("contacts %+v, want b a meter away for a minute", report.Contacts)
+	}
+
+	for _, bad := range []ProximityParameters{
+		{StartTime: day0, EndTime: day0.Add(time.Hour), Radius: 2},
+		{DeviceID: "target", StartTime: day0, EndTime: day0.Add(time.Hour)},
+		{DeviceID: "target", Radius: 2},
+		{DeviceID: "target", StartTime: day0, EndTime: day0, Radius: 2},
+	} {
+		if _, err := c.HistoryService.Proximity(context.Background(), bad); err == nil {
+			t.Errorf("Proximity(%+v) expected error", bad)
+		}
+	}
+}
+
+func TestProximityLimits(t *testing.T) {
+	p := &proximityServer{
+		paths: map[string]HistoryClientsDeviceResponse{
+			"target": devicePath("f1", [2]float64{0, 0}, [2]float64{50, 0}, [2]float64{100, 0}),
+		},
+		near: []string{"z", "a"},
+	}
+	c := newProximityClient(t, p)
+	params := proximityParams()
+	params.MaxRequests = 3
+	report, err := c.HistoryService.Proximity(context.Background(), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	searches, fetched := p.requests()
+	if !report.Truncated || len(searches) != 2 || len(fetched) != 1 {
+		t.Errorf("made %d searches and fetched %q, truncated %v, want 3 requests", len(searches), fetched, report.Truncated)
+	}
+
+	params.MaxRequests = 5
+	if report, err = c.HistoryService.Proximity(context.Background(), params); err != nil {
+		t.Fatal(err)
+	}
+	searches, fetched = p.requests()
+	if !report.Truncated || len(searches) != 3 || len(fetched) != 2 || fetched[1] != "a" {
+		t.Errorf("made %d searches and fetched %q, truncated %v, want the first candidate compared", len(searches), fetched, report.Truncated)
+	}
+
+	p.mu.Lock()
+	p.fail = "a"
+	p.mu.Unlock()
+	params.MaxRequests = 0
+	params.Concurrency = 1
+	if _, err := c.HistoryService.Proximity(context.Background(), params); !errors.Is(err, ErrInternalError) {
+		t.Errorf("err = %v, want ErrInternalError", err)
+	}
+	// With one request at a time, the candidate after the failure isn't fetched.
+	if _, fetched = p.requests(); len(fetched) != 2 {
+		t.Errorf("fetched %q after a failure, want the rest cancelled", fetched)
+	}
+}
+
+func TestForEachLimited(t *testing.T) {
+	var mu sync.Mutex
+	running, peak, calls := 0, 0, 0
+	err := forEachLimited(context.Background(), 20, 3, func(ctx context.Context, i int) error {
+		mu.Lock()
+		running++
+		calls++
+		if running > peak {
+			peak = running
+		}
+		mu.Unlock()
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return nil
+	})
+	if err != nil || calls != 20 || peak > 3 {
+		t.Errorf("err %v after %d calls with %d at once, want 20 calls with at most 3 at once", err, calls, peak)
+	}
+
+	failed := errors.New("failed")
+	err = forEachLimited(context.Background(), 20, 2, func(ctx context.Context, i int) error {
+		if i == 1 {
+			return failed
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if err != failed {
+		t.Errorf("err = %v, want the first error", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := forEachLimited(ctx, 5, 2, func(ctx context.Context, i int) error { return nil }); err != context.Canceled {
+		t.Errorf("err = %v with a cancelled context, want context.Canceled", err)
+	}
+	if err := forEachLimited(context.Background(), 0, 2, nil); err != nil {
+		t.Errorf("err = %v for no calls", err)
+	}
+}
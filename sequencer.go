@@ -0,0 +1,356 @@
+package dnas
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Defaults used by NewEventSequencer when zero is given.
+const (
+	DefaultDedupeWindow = 10 * time.Minute
+	DefaultWatermark    = 2 * time.Second
+)
+
+// SequencerStats reports what an EventSequencer has done.
+type SequencerStats struct {
+	Received   int `json:"received"`
+	Delivered  int `json:"delivered"`
+	Duplicates int `json:"duplicates"`
+
+	// Reordered is the number of events that arrived before an event with an earlier timestamp for the same device.
+	Reordered int `json:"reordered"`
+
+	// Late is the number of events that arrived after a later event for the same device had already been delivered,
+	// and DroppedLate is how many of those were discarded.  MaxLateness is the furthest behind a late event has been.
+	Late        int           `json:"late"`
+	DroppedLate int           `json:"droppedLate"`
+	MaxLateness time.Duration `json:"maxLateness"`
+
+	// Pending is the number of events currently held waiting for the watermark.
+	Pending int `json:"pending"`
+
+	// Errors is the number of events the next handler returned an error for.
+	Errors int `json:"errors"`
+}
+
+// EventSequencer is a middleware stage for notifications, which are delivered at least once and may arrive out of
+// order.  Duplicate events are dropped, and events for each device are held for the watermark delay so that they can
+// be passed to the next handler in timestamp order.  Handle can be used as the EventHandler for a WebhookHandler or
+// Stream, with the next handler typically an EventBus's Publish.
+//
+// Handle doesn't return until the event has been passed to the next handler, and returns its error, so a
+// WebhookHandler only acknowledges an event, and an EventQueue only commits it, once it has been delivered.
+// While reordering, each call therefore waits for the watermark delay, so events must be handled concurrently, as
+// a WebhookHandler does, for them to be reordered.  A sequential source such as EventQueue.Run should disable
+// reordering with a negative watermark.
+//
+// An event's key is recorded when it arrives, so that a duplicate arriving while it is held is dropped.  The key is
+// forgotten if the next handler returns an error, or Handle gives up waiting, so that a redelivery is handled again.
+type EventSequencer struct {
+	// delivered and errors are updated atomically while delivering, so are first for 64 bit alignment.
+	delivered int64
+	errors    int64
+
+	// DropLate discards events that arrive after a later event for the same device has been delivered.
+	// Otherwise they are delivered out of order and counted in SequencerStats.Late.
+	DropLate bool
+
+	next      EventHandler
+	window    time.Duration
+	watermark time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu       sync.Mutex
+	closed   bool
+	seen     map[string]time.Time
+	pending  map[string][]pendingEvent
+	released map[string]releasedEvent
+	stats    SequencerStats
+
+	// deliverMu keeps deliveries in the order they were released.
+	deliverMu sync.Mutex
+}
+
+// pendingEvent is an event held for the watermark delay.  The result of delivering it is sent to result.
+type pendingEvent struct {
+	ev      Event
+	arrived time.Time
+	result  chan error
+}
+
+// releasedEvent records the timestamp of the last event delivered for a device.
+type releasedEvent struct {
+	timestamp int64
+	at        time.Time
+}
+
+// NewEventSequencer returns an EventSequencer passing events to next.  Duplicates are detected for the given window,
+// and events are held for the watermark delay to be reordered.  Zero selects DefaultDedupeWindow or DefaultWatermark,
+// while a negative watermark disables reordering so that events are passed on as soon as they are received.
+// Close must be called to stop the sequencer.
+func NewEventSequencer(next EventHandler, window, watermark time.Duration) *EventSequencer {
+	if window <= 0 {
+		window = DefaultDedupeWindow
+	}
+	if watermark == 0 {
+		watermark = DefaultWatermark
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &EventSequencer{
+		next:      next,
+		window:    window,
+		watermark: watermark,
+		ctx:       ctx,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+		seen:      make(map[string]time.Time),
+		pending:   make(map[string][]pendingEvent),
+		released:  make(map[string]releasedEvent),
+	}
+	go s.run()
+	return s
+}
+
+// Handle accepts an event, passes it to the next handler once any earlier events for the device have been, and
+// returns the next handler's error.  Duplicates and late events that are dropped return nil.  If the context is done
+// while the event is held, the event is discarded and the context's error returned.  After Close, ErrSequencerClosed
+// is returned.
+func (s *EventSequencer) Handle(ctx context.Context, ev Event) error {
+	now := time.Now()
+	h := ev.Header()
+	device := eventKey(ev)
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrSequencerClosed
+	}
+	s.stats.Received++
+	key := dedupeKey(ev)
+	if _, ok := s.seen[key]; ok {
+		s.stats.Duplicates++
+		s.mu.Unlock()
+		return nil
+	}
+	s.seen[key] = now
+
+	if r, ok := s.released[device]; ok && h.Timestamp < r.timestamp {
+		s.stats.Late++
+		if lateness := time.Duration(r.timestamp-h.Timestamp) * time.Millisecond; lateness > s.stats.MaxLateness {
+			s.stats.MaxLateness = lateness
+		}
+		if s.DropLate {
+			s.stats.DroppedLate++
+			s.mu.Unlock()
+			return nil
+		}
+	}
+
+	if s.watermark < 0 {
+		s.markReleased(device, h.Timestamp, now)
+		s.deliverMu.Lock()
+		s.mu.Unlock()
+		err := s.deliver(ctx, ev)
+		s.deliverMu.Unlock()
+		if err != nil {
+			s.forget(key)
+		}
+		return err
+	}
+
+	queue := s.pending[device]
+	i := sort.Search(len(queue), func(i int) bool { return queue[i].ev.Header().Timestamp > h.Timestamp })
+	if i < len(queue) {
+		s.stats.Reordered++
+	}
+	result := make(chan error, 1)
+	queue = append(queue, pendingEvent{})
+	copy(queue[i+1:], queue[i:])
+	queue[i] = pendingEvent{ev: ev, arrived: now, result: result}
+	s.pending[device] = queue
+	s.stats.Pending++
+	s.mu.Unlock()
+
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+	}
+	if s.discard(device, key, result) {
+		return ctx.Err()
+	}
+	// The event was released before it could be discarded, so wait for its delivery.
+	return <-result
+}
+
+// discard removes a held event, identified by its result channel, and forgets its key.  It reports whether the event
+// was still held.
+func (s *EventSequencer) discard(device, key string, result chan error) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	queue := s.pending[device]
+	for i, p := range queue {
+		if p.result != result {
+			continue
+		}
+		if len(queue) == 1 {
+			delete(s.pending, device)
+		} else {
+			s.pending[device] = append(queue[:i:i], queue[i+1:]...)
+		}
+		s.stats.Pending--
+		delete(s.seen, key)
+		return true
+	}
+	return false
+}
+
+// Stats returns a copy of the statistics.
+func (s *EventSequencer) Stats() SequencerStats {
+	s.mu.Lock()
+	stats := s.stats
+	s.mu.Unlock()
+	stats.Delivered = int(atomic.LoadInt64(&s.delivered))
+	stats.Errors = int(atomic.LoadInt64(&s.errors))
+	return stats
+}
+
+// Flush delivers every held event without waiting for the watermark.
+func (s *EventSequencer) Flush() {
+	s.release(true)
+}
+
+// Close stops the sequencer and delivers the events still held.  Events handled after Close are rejected.
+func (s *EventSequencer) Close() {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.cancel()
+	<-s.done
+	s.release(true)
+}
+
+// run releases events as their watermark passes and forgets old dedupe keys.
+func (s *EventSequencer) run() {
+	defer close(s.done)
+	interval := s.watermark / 2
+	if interval <= 0 || interval > time.Second {
+		interval = time.Second
+	}
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			s.release(false)
+			s.expire()
+		}
+	}
+}
+
+// release delivers, in timestamp order, the events for each device whose watermark has passed, or every event if all is set.
+func (s *EventSequencer) release(all bool) {
+	now := time.Now()
+	s.mu.Lock()
+	var due []pendingEvent
+	for device, queue := range s.pending {
+		n := 0
+		for n < len(queue) && (all || now.Sub(queue[n].arrived) >= s.watermark) {
+			n++
+		}
+		if n == 0 {
+			continue
+		}
+		due = append(due, queue[:n]...)
+		s.markReleased(device, queue[n-1].ev.Header().Timestamp, now)
+		if n == len(queue) {
+			delete(s.pending, device)
+		} else {
+			s.pending[device] = queue[n:]
+		}
+		s.stats.Pending -= n
+	}
+	s.deliverMu.Lock()
+	s.mu.Unlock()
+
+	var failed []string
+	for _, p := range due {
+		err := s.deliver(context.Background(), p.ev)
+		if err != nil {
+			failed = append(failed, dedupeKey(p.ev))
+		}
+		p.result <- err
+	}
+	s.deliverMu.Unlock()
+	for _, key := range failed {
+		s.forget(key)
+	}
+}
+
+// forget removes a dedupe key so that the event is accepted if it is received again.
+// It must not be called with deliverMu held, as mu is always locked first.
+func (s *EventSequencer) forget(key string) {
+	s.mu.Lock()
+	delete(s.seen, key)
+	s.mu.Unlock()
+}
+
+// deliver passes the event to the next handler and records the result.  It must be called with deliverMu held.
+func (s *EventSequencer) deliver(ctx context.Context, ev Event) error {
+	err := s.next(ctx, ev)
+	if err != nil {
+		atomic.AddInt64(&s.errors, 1)
+	} else {
+		atomic.AddInt64(&s.delivered, 1)
+	}
+	return err
+}
+
+// markReleased records the timestamp of the latest event delivered for the device.  It must be called with mu held.
+func (s *EventSequencer) markReleased(device string, timestamp int64, now time.Time) {
+	if r, ok := s.released[device]; ok && r.timestamp > timestamp {
+		timestamp = r.timestamp
+	}
+	s.released[device] = releasedEvent{timestamp: timestamp, at: now}
+}
+
+// expire forgets dedupe keys and devices not seen within the window.
+func (s *EventSequencer) expire() {
+	cutoff := time.Now().Add(-s.window)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, t := range s.seen {
+		if t.Before(cutoff) {
+			delete(s.seen, k)
+		}
+	}
+	for device, r := range s.released {
+		if r.at.Before(cutoff) {
+			delete(s.released, device)
+		}
+	}
+}
+
+// dedupeKey returns the event ID, or if the event has no ID a hash of the device, timestamp and type.
+func dedupeKey(ev Event) string {
+	h := ev.Header()
+	if h.ID != "" {
+		return "id:" + h.ID
+	}
+	f := fnv.New64a()
+	fmt.Fprintf(f, "%s|%d|%s", eventKey(ev), h.Timestamp, h.Type)
+	return fmt.Sprintf("hash:%x", f.Sum64())
+}
@@ -0,0 +1,189 @@
+package dnas
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder is a next handler that records the IDs of the events it is given, failing those in fail.
+type recorder struct {
+	mu   sync.Mutex
+	ids  []string
+	fail map[string]bool
+}
+
+func (r *recorder) handle(ctx context.Context, ev Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	id := ev.Header().ID
+	if r.fail[id] {
+		delete(r.fail, id)
+		return errors.New("failed")
+	}
+	r.ids = append(r.ids, id)
+	return nil
+}
+
+func (r *recorder) delivered() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.ids...)
+}
+
+func deviceEvent(id, mac string, ts int64) *DeviceEvent {
+	return &DeviceEvent{
+		EventHeader: EventHeader{ID: id, Type: EventDeviceLocationUpdate, Timestamp: ts},
+		Device:      LocationDevice{MacAddress: mac},
+	}
+}
+
+func TestSequencerDropsDuplicates(t *testing.T) {
+	r := &recorder{}
+	s := NewEventSequencer(r.handle, time.Minute, -1)
+	defer s.Close()
+	for _, id := range []string{"1", "1", "2"} {
+		if err := s.Handle(context.Background(), deviceEvent(id, "a", 1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Without an ID, the device, timestamp and type are used.
+	s.Handle(context.Background(), deviceEvent("", "a", 5))
+	s.Handle(context.Background(), deviceEvent("", "a", 5))
+
+	if got := r.delivered(); len(got) != 3 {
+		t.Errorf("delivered = %q, want 3 events", got)
+	}
+	if stats := s.Stats(); stats.Duplicates != 2 || stats.Received != 5 || stats.Delivered != 3 {
+		t.Errorf("stats = %+v, want 2 duplicates of 5 received", stats)
+	}
+}
+
+func TestSequencerReorders(t *testing.T) {
+	r := &recorder{}
+	s := NewEventSequencer(r.handle, time.Minute, 100*time.Millisecond)
+	defer s.Close()
+
+	var wg sync.WaitGroup
+	for _, ev := range []*DeviceEvent{deviceEvent("3", "a", 3), deviceEvent("1", "a", 1), deviceEvent("2", "a", 2)} {
+		wg.Add(1)
+		go func(ev *DeviceEvent) {
+			defer wg.Done()
+			if err := s.Handle(context.Background(), ev); err != nil {
+				t.Error(err)
+			}
+		}(ev)
+		// Let each event arrive in turn, well within the watermark.
+		time.Sleep(10 * time.Millisecond)
+	}
+	wg.Wait()
+
+	got := r.delivered()
+	if len(got) != 3 || got[0] != "1" || got[1] != "2" || got[2] != "3" {
+		t.Errorf("delivered = %q, want [1 2 3]", got)
+	}
+	if stats := s.Stats(); stats.Reordered != 2 || stats.Pending != 0 {
+		t.Errorf("stats = %+v, want 2 reordered and none pending", stats)
+	}
+}
+
+func TestSequencerHoldsUntilWatermark(t *testing.T) {
+	r := &recorder{}
+	s := NewEventSequencer(r.handle, time.Minute, 200*time.Millisecond)
+	defer s.Close()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- s.Handle(context.Background(), deviceEvent("1", "a", 1)) }()
+	time.Sleep(50 * time.Millisecond)
+	if got := r.delivered(); len(got) != 0 {
+		t.Errorf("delivered %q before the watermark", got)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("Handle returned after %s, want at least the watermark", elapsed)
+	}
+	if got := r.delivered(); len(got) != 1 {
+		t.Errorf("delivered = %q, want [1]", got)
+	}
+}
+
+func TestSequencerLateEvents(t *testing.T) {
+	r := &recorder{}
+	s := NewEventSequencer(r.handle, time.Minute, -1)
+	defer s.Close()
+	s.DropLate = true
+	s.Handle(context.Background(), deviceEvent("2", "a", 2000))
+	s.Handle(context.Background(), deviceEvent("1", "a", 500))
+	s.Handle(context.Background(), deviceEvent("3", "b", 1))
+
+	if got := r.delivered(); len(got) != 2 || got[0] != "2" || got[1] != "3" {
+		t.Errorf("delivered = %q, want [2 3]", got)
+	}
+	stats := s.Stats()
+	if stats.Late != 1 || stats.DroppedLate != 1 || stats.MaxLateness != 1500*time.Millisecond {
+		t.Errorf("stats = %+v, want 1 late event dropped 1.5s behind", stats)
+	}
+}
+
+func TestSequencerForgetsFailedEvents(t *testing.T) {
+	for _, watermark := range []time.Duration{-1, 20 * time.Millisecond} {
+		r := &recorder{fail: map[string]bool{"1": true}}
+		s := NewEventSequencer(r.handle, time.Minute, watermark)
+		if err := s.Handle(context.Background(), deviceEvent("1", "a", 1)); err == nil {
+			t.Errorf("watermark %s: expected the next handler's error", watermark)
+		}
+		if err := s.Handle(context.Background(), deviceEvent("1", "a", 1)); err != nil {
+			t.Errorf("watermark %s: redelivery: %v", watermark, err)
+		}
+		s.Close()
+		if got := r.delivered(); len(got) != 1 {
+			t.Errorf("watermark %s: delivered = %q, want the redelivered event", watermark, got)
+		}
+		if stats := s.Stats(); stats.Duplicates != 0 || stats.Errors != 1 {
+			t.Errorf("watermark %s: stats = %+v, want no duplicates and 1 error", watermark, stats)
+		}
+	}
+}
+
+func TestSequencerContextDone(t *testing.T) {
+	r := &recorder{}
+	s := NewEventSequencer(r.handle, time.Minute, time.Hour)
+	defer s.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := s.Handle(ctx, deviceEvent("1", "a", 1)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want context.DeadlineExceeded", err)
+	}
+	if stats := s.Stats(); stats.Pending != 0 {
+		t.Errorf("pending = %d, want the event discarded", stats.Pending)
+	}
+	s.Flush()
+	if got := r.delivered(); len(got) != 0 {
+		t.Errorf("delivered = %q, want none", got)
+	}
+}
+
+func TestSequencerClose(t *testing.T) {
+	r := &recorder{}
+	s := NewEventSequencer(r.handle, time.Minute, time.Hour)
+	done := make(chan error, 1)
+	go func() { done <- s.Handle(context.Background(), deviceEvent("1", "a", 1)) }()
+	for s.Stats().Pending == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	s.Close()
+	if err := <-done; err != nil {
+		t.Errorf("held event: %v", err)
+	}
+	if got := r.delivered(); len(got) != 1 {
+		t.Errorf("delivered = %q, want the held event", got)
+	}
+	if err := s.Handle(context.Background(), deviceEvent("2", "a", 2)); err != ErrSequencerClosed {
+		t.Errorf("err = %v, want ErrSequencerClosed", err)
+	}
+}
@@ -0,0 +1,239 @@
+package dnas
+
+import (
+	"sort"
+	"time"
+)
+
+// DefaultSessionGap is the inactivity gap used by a Sessionizer when none is given.
+const DefaultSessionGap = 30 * time.Minute
+
+// VisitPoint is a single observation of a device used to build sessions.
+// Use VisitPointsFromHistory or VisitPointsFromClientHistory to create them from the history API.
+type VisitPoint struct {
+	MacAddress string
+	Timestamp  time.Time
+	CampusID   string
+	BuildingID string
+	FloorID    string
+	SSID       string
+	Associated bool
+}
+
+// Session represents a single visit by a device, i.e. a run of points on one campus with no gap longer than the Sessionizer Gap.
+type Session struct {
+	MacAddress string        `json:"macAddress"`
+	CampusID   string        `json:"campusId,omitempty"`
+	Start      time.Time     `json:"start"`
+	End        time.Time     `json:"end"`
+	Duration   time.Duration `json:"duration"`
+
+	// Floors and Buildings are the unique identifiers visited during the session, in the order they were first seen.
+	Floors    []string `json:"floors,omitempty"`
+	Buildings []string `json:"buildings,omitempty"`
+
+	// PrimarySSID is the SSID seen most often during the session.
+	PrimarySSID string `json:"primarySsid,omitempty"`
+
+	// Associated is true if the device was associated at any point during the session.
+	Associated bool `json:"associated"`
+
+	// Points is the number of observations that make up the session.
+	Points int `json:"points"`
+}
+
+// Sessionizer splits device observations into sessions using an inactivity gap.
+type Sessionizer struct {
+	// Gap is the maximum time between two observations of the same device in the same session.
+	// If zero, DefaultSessionGap is used.
+	Gap time.Duration
+}
+
+// NewSessionizer returns a Sessionizer using the given inactivity gap.
+func NewSessionizer(gap time.Duration) *Sessionizer {
+	return &Sessionizer{Gap: gap}
+}
+
+// VisitPointsFromHistory converts the results of GetHistory into visit points.
+// Records with a timestamp that can't be parsed are skipped, and the number skipped is returned.
+func VisitPointsFromHistory(items []HistoryItem) ([]VisitPoint, int) {
+	points := make([]VisitPoint, 0, len(items))
+	skipped := 0
+	for _, item := range items {
+		ts, err := item.Timestamp()
+		if err != nil {
+			skipped++
+			continue
+		}
+		points = append(points, VisitPoint{
+			MacAddress: item.MacAddress,
+			Timestamp:  ts,
+			CampusID:   item.CampusID,
+			BuildingID: item.BuildingID,
+			FloorID:    item.FloorID,
+			SSID:       item.Ssid,
+			Associated: item.IsAssociated(),
+		})
+	}
+	return points, skipped
+}
+
+// VisitPointsFromClientHistory converts the results of GetClient into visit points for the given mac address.
+// GetClient does not provide the campus, building or SSID, so these will be empty.
+func VisitPointsFromClientHistory(macAddress string, h HistoryClientsDeviceResponse) []VisitPoint {
+	points := make([]VisitPoint, 0, len(h))
+	for _, item := range h {
+		points = append(points, VisitPoint{
+			MacAddress: macAddress,
+			Timestamp:  item.Timestamp(),
+			FloorID:    item.FloorID,
+			Associated: item.Associated,
+		})
+	}
+	return points
+}
+
+// Sessions groups the points by mac address and splits them into sessions.
+// A session ends at a gap longer than the Gap, or when the device is seen on a different campus.
+// Points without a campus don't end a session.  The points do not need to be sorted.  Sessions are returned ordered by mac address and start time.
+func (s *Sessionizer) Sessions(points []VisitPoint) []Session {
+	gap := s.Gap
+	if gap <= 0 {
+		gap = DefaultSessionGap
+	}
+
+	byMac := make(map[string][]VisitPoint)
+	var macs []string
+	for _, p := range points {
+		if _, ok := byMac[p.MacAddress]; !ok {
+			macs = append(macs, p.MacAddress)
+		}
+		byMac[p.MacAddress] = append(byMac[p.MacAddress], p)
+	}
+	sort.Strings(macs)
+
+	var sessions []Session
+	for _, mac := range macs {
+		pts := byMac[mac]
+		sort.SliceStable(pts, func(i, j int) bool { return pts[i].Timestamp.Before(pts[j].Timestamp) })
+		start := 0
+		campus := pts[0].CampusID
+		for i := 1; i <= len(pts); i++ {
+			if i == len(pts) || pts[i].Timestamp.Sub(pts[i-1].Timestamp) > gap ||
+				(campus != "" && pts[i].CampusID != "" && pts[i].CampusID != campus) {
+				sessions = append(sessions, newSession(pts[start:i]))
+				start = i
+				if i < len(pts) {
+					campus = pts[i].CampusID
+				}
+				continue
+			}
+			if campus == "" {
+				campus = pts[i].CampusID
+			}
+		}
+	}
+	return sessions
+}
+
+// newSession summarises a sorted, non-empty run of points.
+func newSession(pts []VisitPoint) Session {
+	s := Session{
+		MacAddress: pts[0].MacAddress,
+		Start:      pts[0].Timestamp,
+		End:        pts[len(pts)-1].Timestamp,
+		Points:     len(pts),
+	}
+	s.Duration = s.End.Sub(s.Start)
+
+	seenFloors := make(map[string]bool)
+	seenBuildings := make(map[string]bool)
+	ssids := make(map[string]int)
+	campuses := make(map[string]int)
+	for _, p := range pts {
+		if p.FloorID != "" && !seenFloors[p.FloorID] {
+			seenFloors[p.FloorID] = true
+			s.Floors = append(s.Floors, p.FloorID)
+		}
+		if p.BuildingID != "" && !seenBuildings[p.BuildingID] {
+			seenBuildings[p.BuildingID] = true
+			s.Buildings = append(s.Buildings, p.BuildingID)
+		}
+		if p.SSID != "" {
+			ssids[p.SSID]++
+		}
+		if p.CampusID != "" {
+			campuses[p.CampusID]++
+		}
+		if p.Associated {
+			s.Associated = true
+		}
+	}
+	s.PrimarySSID = mostFrequent(ssids)
+	s.CampusID = mostFrequent(campuses)
+	return s
+}
+
+// mostFrequent returns the key with the highest count, choosing the lowest key on a tie so results are stable.
+func mostFrequent(counts map[string]int) string {
+	var best string
+	bestCount := 0
+	for k, n := range counts {
+		if n > bestCount || (n == bestCount && k < best) {
+			best, bestCount = k, n
+		}
+	}
+	return best
+}
+
+// VisitsPerDay returns the number of sessions starting on each day, keyed by date in the form 2006-01-02.
+// Days are calculated in the given location, or UTC if loc is nil.
+func VisitsPerDay(sessions []Session, loc *time.Location) map[string]int {
+	if loc == nil {
+		loc = time.UTC
+	}
+	visits := make(map[string]int)
+	for _, s := range sessions {
+		visits[s.Start.In(loc).Format("2006-01-02")]++
+	}
+	return visits
+}
+
+// MedianDwell returns the median session duration, or zero if there are no sessions.
+func MedianDwell(sessions []Session) time.Duration {
+	if len(sessions) == 0 {
+		return 0
+	}
+	durations := make([]time.Duration, len(sessions))
+	for i, s := range sessions {
+		durations[i] = s.Duration
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	mid := len(durations) / 2
+	if len(durations)%2 == 0 {
+		return (durations[mid-1] + durations[mid]) / 2
+	}
+	return durations[mid]
+}
+
+// ReturnVisitors returns, for each campus, the number of devices with more than one session on that campus.
+// Sessions without a campus are grouped under the empty string.
+func ReturnVisitors(sessions []Session) map[string]int {
+	visits := make(map[string]map[string]int)
+	for _, s := range sessions {
+		if visits[s.CampusID] == nil {
+			visits[s.CampusID] = make(map[string]int)
+		}
+		visits[s.CampusID][s.MacAddress]++
+	}
+	returning := make(map[string]int)
+	for campus, devices := range visits {
+		returning[campus] = 0
+		for _, n := range devices {
+			if n > 1 {
+				returning[campus]++
+			}
+		}
+	}
+	return returning
+}
@@ -0,0 +1,100 @@
+package dnas
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestVisitPointsFromHistory(t *testing.T) {
+	ms := func(d time.Duration) string { return fmt.Sprint(timeToMillis(day0.Add(d))) }
+	points, skipped := VisitPointsFromHistory([]HistoryItem{
+		{MacAddress: "a", SourceTimestamp: ms(0), CampusID: "c1", BuildingID: "b1", FloorID: "f1", Ssid: "guest", Associated: "TRUE"},
+		{MacAddress: "a", SourceTimestamp: "yesterday"},
+		{MacAddress: "a", SourceTimestamp: ms(time.Minute)},
+	})
+	if skipped != 1 || len(points) != 2 {
+		t.Fatalf("%d points with %d skipped, want 2 with 1 skipped", len(points), skipped)
+	}
+	want := VisitPoint{MacAddress: "a", Timestamp: day0, CampusID: "c1", BuildingID: "b1", FloorID: "f1", SSID: "guest", Associated: true}
+	if !points[0].Timestamp.Equal(day0) || points[0].SSID != want.SSID || points[0].FloorID != want.FloorID || !points[0].Associated {
+		t.Errorf("point %+v, want %+v", points[0], want)
+	}
+	if !points[1].Timestamp.Equal(day0.Add(time.Minute)) {
+		t.Errorf("point %+v, want a minute later", points[1])
+	}
+
+	client := VisitPointsFromClientHistory("b", devicePath("f2", [2]float64{1, 2}))
+	if len(client) != 1 || client[0].MacAddress != "b" || client[0].FloorID != "f2" || !client[0].Timestamp.Equal(day0) {
+		t.Errorf("client points %+v", client)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	at := func(mac, campus, building, floor, ssid string, d time.Duration) VisitPoint {
+		return VisitPoint{MacAddress: mac, Timestamp: day0.Add(d), CampusID: campus, BuildingID: building, FloorID: floor, SSID: ssid}
+	}
+	points := []VisitPoint{
+		at("b", "c1", "b1", "f1", "", 0),
+		at("a", "c1", "b1", "f2", "staff", 10*time.Minute),
+		at("a", "c1", "b1", "f1", "guest", 0),
+		at("a", "", "", "", "staff", 20*time.Minute),
+		// A gap longer than the default.
+		at("a", "c1", "b2", "f3", "", 2*time.Hour),
+		// A different campus without a gap.
+		at("a", "c2", "b3", "f4", "", 2*time.Hour+time.Minute),
+		at("a", "c2", "b3", "f4", "", 2*time.Hour+2*time.Minute),
+	}
+	points[2].Associated = true
+	sessions := NewSessionizer(0).Sessions(points)
+	if len(sessions) != 4 {
+		t.Fatalf("%d sessions %+v, want 4", len(sessions), sessions)
+	}
+
+	first := sessions[0]
+	if first.MacAddress != "a" || !first.Start.Equal(day0) || first.Duration != 20*time.Minute || first.Points != 3 ||
+		first.CampusID != "c1" || first.PrimarySSID != "staff" || !first.Associated ||
+		fmt.Sprint(first.Floors, first.Buildings) != "[f1 f2] [b1]" {
+		t.Errorf("first session %+v", first)
+	}
+	if s := sessions[1]; s.CampusID != "c1" || s.Points != 1 || s.Associated {
+		t.Errorf("second session %+v, want the point after the gap", s)
+	}
+	if s := sessions[2]; s.CampusID != "c2" || s.Points != 2 || s.Duration != time.Minute {
+		t.Errorf("third session %+v, want the points on the second campus", s)
+	}
+	if s := sessions[3]; s.MacAddress != "b" || s.Points != 1 {
+		t.Errorf("fourth session %+v", s)
+	}
+
+	if n := len(NewSessionizer(time.Hour * 3).Sessions(points[:5])); n != 2 {
+		t.Errorf("%d sessions with a longer gap, want 2", n)
+	}
+}
+
+func TestSessionStats(t *testing.T) {
+	sessions := []Session{
+		{MacAddress: "a", CampusID: "c1", Start: day0.Add(23 * time.Hour), Duration: time.Minute},
+		{MacAddress: "a", CampusID: "c1", Start: day0.Add(25 * time.Hour), Duration: 3 * time.Minute},
+		{MacAddress: "b", CampusID: "c1", Start: day0, Duration: 10 * time.Minute},
+		{MacAddress: "b", CampusID: "c2", Start: day0, Duration: 20 * time.Minute},
+	}
+	if v := VisitsPerDay(sessions, nil); v["2021-03-01"] != 3 || v["2021-03-02"] != 1 {
+		t.Errorf("visits per day %v", v)
+	}
+	if v := VisitsPerDay(sessions, time.FixedZone("UTC+2", 2*60*60)); v["2021-03-02"] != 2 {
+		t.Errorf("visits per day %v in UTC+2, want 2 on the second day", v)
+	}
+	if d := MedianDwell(sessions); d != 6*time.Minute+30*time.Second {
+		t.Errorf("median dwell %v, want 6m30s", d)
+	}
+	if d := MedianDwell(sessions[:3]); d != 3*time.Minute {
+		t.Errorf("median dwell %v, want 3m", d)
+	}
+	if d := MedianDwell(nil); d != 0 {
+		t.Errorf("median dwell %v with no sessions", d)
+	}
+	if r := ReturnVisitors(sessions); r["c1"] != 1 || r["c2"] != 0 || len(r) != 2 {
+		t.Errorf("return visitors %v, want 1 on c1", r)
+	}
+}
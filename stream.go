@@ -0,0 +1,350 @@
+package dnas
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults used by Stream when the StreamOptions are not given.
+const (
+	DefaultStreamIdleTimeout = time.Minute
+	DefaultStreamMinBackoff  = time.Second
+	DefaultStreamMaxBackoff  = time.Minute
+)
+
+// StreamOptions represent the options for Stream()
+type StreamOptions struct {
+	// URL of the event stream.  If empty, the notifications stream of the client's BaseURL is used.
+	URL string
+
+	// EventTypes limits the stream to the given event types.  If empty, all events are received.
+	EventTypes []EventType
+
+	// LastEventID resumes the stream after the given event.  Reconnects always resume after the last event received.
+	LastEventID string
+
+	// Since resumes the stream from the given time when no LastEventID is known.
+	Since time.Time
+
+	// IdleTimeout is how long the connection may go without receiving anything, including heartbeats,
+	// before it is considered dead and reconnected.  If zero, DefaultStreamIdleTimeout is used.
+	IdleTimeout time.Duration
+
+	// MinBackoff and MaxBackoff bound the exponential delay between reconnects.
+	// If zero, DefaultStreamMinBackoff and DefaultStreamMaxBackoff are used.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// OnDisconnect, if set, is called each time the connection is lost with the reason and the delay before reconnecting.
+	OnDisconnect func(err error, retryIn time.Duration)
+
+	// OnDecodeError, if set, is called with the payload of each event that can't be decoded.  Such events are
+	// skipped, and the stream resumes after them, so that they are not received again on every reconnect.
+	OnDecodeError func(payload []byte, err error)
+}
+
+// errStreamIdle is the reason given to OnDisconnect when the connection has been idle for too long.
+var errStreamIdle = errors.New("dnas: stream idle timeout")
+
+// Stream connects to the DNA Spaces event stream and calls fn for each event until the context is cancelled,
+// fn returns an error, or the API rejects the request with ErrBadRequest, ErrUnauthorized or ErrForbidden.
+// Both server-sent events and newline delimited or concatenated JSON streams are supported, chosen by the
+// Content-Type of the response.  Heartbeats sent by the server keep the connection alive, and the connection
+// is re-established with exponential backoff when it drops or goes idle, resuming from the last event received.
+// The client's HTTPClient is used without its Timeout, which would otherwise end the stream.
+//
+// The stream is a single GET request, so once connected there is no way for the client to send heartbeats of its
+// own.  Instead a dead connection is detected by the server's heartbeats stopping for IdleTimeout, and the TCP
+// keep-alives of the default transport stop idle connections being dropped by NAT and firewalls.
+//
+// Events that can't be decoded are passed to OnDecodeError and skipped.  In a JSON stream, a value that isn't valid
+// JSON can't be skipped, as the end of it can't be found, so the connection is re-established instead.
+func (s *NotificationsService) Stream(ctx context.Context, opts *StreamOptions, fn EventHandler) error {
+	o := StreamOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.IdleTimeout <= 0 {
+		o.IdleTimeout = DefaultStreamIdleTimeout
+	}
+	if o.MinBackoff <= 0 {
+		o.MinBackoff = DefaultStreamMinBackoff
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = DefaultStreamMaxBackoff
+	}
+
+	client := *s.client
+	hc := http.Client{}
+	if client.HTTPClient != nil {
+		hc = *client.HTTPClient
+	}
+	hc.Timeout = 0
+	client.HTTPClient = &hc
+
+	st := &streamState{opts: &o, fn: fn, backoff: o.MinBackoff}
+	for {
+		err := st.connect(ctx, &client)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var handlerErr *streamHandlerError
+		if errors.As(err, &handlerErr) {
+			return handlerErr.err
+		}
+		if errors.Is(err, ErrBadRequest) || errors.Is(err, ErrUnauthorized) || errors.Is(err, ErrForbidden) {
+			return err
+		}
+		wait := st.nextBackoff()
+		if o.OnDisconnect != nil {
+			o.OnDisconnect(err, wait)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// streamHandlerError wraps an error returned by the EventHandler so that Stream can stop rather than reconnect.
+type streamHandlerError struct {
+	err error
+}
+
+func (e *streamHandlerError) Error() string { return e.err.Error() }
+
+// streamState is the state kept across connections of a Stream.
+type streamState struct {
+	opts     *StreamOptions
+	fn       EventHandler
+	backoff  time.Duration
+	received bool
+	since    int64
+}
+
+// nextBackoff returns the delay before the next reconnect.  The delay is reset if the last connection
+// received an event, and otherwise doubles up to MaxBackoff.  Jitter of up to half the delay is added.
+func (st *streamState) nextBackoff() time.Duration {
+	if st.received {
+		st.backoff = st.opts.MinBackoff
+		st.received = false
+	}
+	wait := st.backoff
+	st.backoff *= 2
+	if st.backoff > st.opts.MaxBackoff {
+		st.backoff = st.opts.MaxBackoff
+	}
+	return wait + time.Duration(rand.Int63n(int64(wait)/2+1))
+}
+
+// connect makes a single connection to the stream and reads events until it ends.
+func (st *streamState) connect(ctx context.Context, c *Client) error {
+	u, err := st.url(c)
+	if err != nil {
+		return err
+	}
+	connCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream, application/x-ndjson, application/json")
+	if st.opts.LastEventID != "" {
+		req.Header.Set("Last-Event-ID", st.opts.LastEventID)
+	}
+	res, err := c.do(connCtx, req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	idle := &idleReader{r: res.Body, timeout: st.opts.IdleTimeout}
+	idle.timer = time.AfterFunc(st.opts.IdleTimeout, func() {
+		idle.expire()
+		cancel()
+	})
+	defer idle.timer.Stop()
+
+	mediaType, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if mediaType == "text/event-stream" {
+		err = st.readSSE(ctx, idle)
+	} else {
+		err = st.readJSON(ctx, idle)
+	}
+	if idle.expired() {
+		return errStreamIdle
+	}
+	if err == nil {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// url returns the stream URL with the resume and filter parameters.
+func (st *streamState) url(c *Client) (string, error) {
+	raw := st.opts.URL
+	if raw == "" {
+		raw = fmt.Sprintf("%s/notifications/stream", c.BaseURL)
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	for _, t := range st.opts.EventTypes {
+		q.Add("eventType", string(t))
+	}
+	since := st.since
+	if since == 0 && !st.opts.Since.IsZero() {
+		since = timeToMillis(st.opts.Since)
+	}
+	if st.opts.LastEventID == "" && since != 0 {
+		q.Set("fromTimestamp", strconv.FormatInt(since, 10))
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// readSSE reads server-sent events, dispatching the data of each event.
+func (st *streamState) readSSE(ctx context.Context, r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), DefaultMaxWebhookBody)
+	var data bytes.Buffer
+	var id string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if data.Len() > 0 {
+				if err := st.dispatch(ctx, data.Bytes(), id); err != nil {
+					return err
+				}
+			}
+			data.Reset()
+			id = ""
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			// Comment, used by servers as a heartbeat.
+			continue
+		}
+		field, value := line, ""
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+		switch field {
+		case "data":
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(value)
+		case "id":
+			id = value
+		case "retry":
+			if ms, err := strconv.ParseInt(value, 10, 64); err == nil && ms > 0 {
+				st.opts.MinBackoff = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// readJSON reads a stream of JSON values, each containing one event or a batch of events.
+// Whitespace between values, such as the newlines of NDJSON or blank heartbeat lines, is ignored.
+func (st *streamState) readJSON(ctx context.Context, r io.Reader) error {
+	dec := json.NewDecoder(r)
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if err := st.dispatch(ctx, raw, ""); err != nil {
+			return err
+		}
+	}
+}
+
+// dispatch decodes the payload and calls the handler for each event, recording the position to resume from.
+// A payload that can't be decoded is skipped, resuming after its identifier if one can be found.
+func (st *streamState) dispatch(ctx context.Context, payload []byte, id string) error {
+	events, err := DecodeEvents(payload)
+	if err != nil {
+		if id == "" {
+			var h EventHeader
+			if json.Unmarshal(payload, &h) == nil {
+				id = h.ID
+			}
+		}
+		if id != "" {
+			st.opts.LastEventID = id
+		}
+		if st.opts.OnDecodeError != nil {
+			st.opts.OnDecodeError(payload, err)
+		}
+		return nil
+	}
+	for _, ev := range events {
+		if err := st.fn(ctx, ev); err != nil {
+			return &streamHandlerError{err}
+		}
+		st.received = true
+		h := ev.Header()
+		if h.ID != "" {
+			st.opts.LastEventID = h.ID
+		}
+		if h.Timestamp > st.since {
+			st.since = h.Timestamp
+		}
+	}
+	if id != "" {
+		st.opts.LastEventID = id
+	}
+	return nil
+}
+
+// idleReader resets the idle timer on every successful read.
+type idleReader struct {
+	r       io.Reader
+	timer   *time.Timer
+	timeout time.Duration
+
+	mu   sync.Mutex
+	idle bool
+}
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.timer.Reset(r.timeout)
+	}
+	return n, err
+}
+
+func (r *idleReader) expire() {
+	r.mu.Lock()
+	r.idle = true
+	r.mu.Unlock()
+}
+
+func (r *idleReader) expired() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.idle
+}
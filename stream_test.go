@@ -0,0 +1,193 @@
+package dnas
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// streamServer serves a scripted response for each connection, recording the Last-Event-ID header of each request.
+type streamServer struct {
+	contentType string
+	responses   []string
+
+	mu          sync.Mutex
+	connections int
+	lastIDs     []string
+}
+
+func (s *streamServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	n := s.connections
+	s.connections++
+	s.lastIDs = append(s.lastIDs, r.Header.Get("Last-Event-ID"))
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", s.contentType)
+	if n < len(s.responses) {
+		fmt.Fprint(w, s.responses[n])
+	}
+	// Returning drops the connection.
+}
+
+func newStreamClient(t *testing.T, s *streamServer) *Client {
+	t.Helper()
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	c, err := NewClient("test-key", "io", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.BaseURL = srv.URL
+	return c
+}
+
+func sseEvent(id, mac string) string {
+	return fmt.Sprintf("id: %s\ndata: {\"eventId\":\"%s\",\"eventType\":\"DEVICE_ENTRY\",\"timestamp\":1,\"device\":{\"macAddress\":\"%s\"}}\n\n", id, id, mac)
+}
+
+func TestStreamReconnectsAndResumes(t *testing.T) {
+	s := &streamServer{contentType: "text/event-stream", responses: []string{
+		": heartbeat\n\n" + sseEvent("1", "a"),
+		sseEvent("2", "b"),
+	}}
+	c := newStreamClient(t, s)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var macs []string
+	disconnects := 0
+	err := c.NotificationsService.Stream(ctx, &StreamOptions{
+		MinBackoff:   time.Millisecond,
+		MaxBackoff:   time.Millisecond,
+		OnDisconnect: func(err error, retryIn time.Duration) { disconnects++ },
+	}, func(ctx context.Context, ev Event) error {
+		macs = append(macs, ev.(*DeviceEvent).Device.MacAddress)
+		if len(macs) == 2 {
+			cancel()
+		}
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if len(macs) != 2 || macs[0] != "a" || macs[1] != "b" {
+		t.Errorf("macs = %v, want [a b]", macs)
+	}
+	if disconnects != 1 {
+		t.Errorf("disconnects = %d, want 1", disconnects)
+	}
+	if s.lastIDs[0] != "" || s.lastIDs[1] != "1" {
+		t.Errorf("Last-Event-ID = %q, want [\"\" \"1\"]", s.lastIDs)
+	}
+}
+
+func TestStreamSkipsUndecodableEvents(t *testing.T) {
+	s := &streamServer{contentType: "text/event-stream", responses: []string{
+		sseEvent("1", "a") + "id: 2\ndata: {\"eventId\":\"2\"}\n\n",
+		sseEvent("3", "b"),
+	}}
+	c := newStreamClient(t, s)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var macs []string
+	var bad []string
+	err := c.NotificationsService.Stream(ctx, &StreamOptions{
+		MinBackoff:    time.Millisecond,
+		MaxBackoff:    time.Millisecond,
+		OnDecodeError: func(payload []byte, err error) { bad = append(bad, string(payload)) },
+	}, func(ctx context.Context, ev Event) error {
+		macs = append(macs, ev.(*DeviceEvent).Device.MacAddress)
+		if len(macs) == 2 {
+			cancel()
+		}
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if len(macs) != 2 || macs[0] != "a" || macs[1] != "b" {
+		t.Errorf("macs = %v, want [a b]", macs)
+	}
+	if len(bad) != 1 {
+		t.Errorf("decode errors = %d, want 1", len(bad))
+	}
+	if s.connections != 2 || s.lastIDs[1] != "2" {
+		t.Errorf("connections = %d with Last-Event-ID %q, want 2 resuming after 2", s.connections, s.lastIDs)
+	}
+}
+
+func TestStreamJSON(t *testing.T) {
+	s := &streamServer{contentType: "application/x-ndjson", responses: []string{
+		`{"eventId":"1","eventType":"AP_DOWN","timestamp":1,"accessPoint":{"apMac":"x"}}` + "\n\n" +
+			`[{"eventId":"2","eventType":"AP_UP","timestamp":2,"accessPoint":{"apMac":"x"}}]` + "\n",
+	}}
+	c := newStreamClient(t, s)
+	stop := errors.New("stop")
+	var types []EventType
+	err := c.NotificationsService.Stream(context.Background(), &StreamOptions{MinBackoff: time.Millisecond}, func(ctx context.Context, ev Event) error {
+		types = append(types, ev.Header().Type)
+		if len(types) == 2 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Fatalf("err = %v, want handler error", err)
+	}
+	if types[0] != EventAPDown || types[1] != EventAPUp {
+		t.Errorf("types = %v, want [AP_DOWN AP_UP]", types)
+	}
+}
+
+func TestStreamIdleTimeout(t *testing.T) {
+	hang := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.(http.Flusher).Flush()
+		select {
+		case <-hang:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(hang)
+	c, _ := NewClient("test-key", "io", nil)
+	c.BaseURL = srv.URL
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var reason error
+	err := c.NotificationsService.Stream(ctx, &StreamOptions{
+		IdleTimeout: 50 * time.Millisecond,
+		MinBackoff:  time.Millisecond,
+		OnDisconnect: func(err error, retryIn time.Duration) {
+			reason = err
+			cancel()
+		},
+	}, func(ctx context.Context, ev Event) error { return nil })
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if reason != errStreamIdle {
+		t.Errorf("disconnect reason = %v, want idle timeout", reason)
+	}
+}
+
+func TestStreamUnauthorized(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+	c, _ := NewClient("test-key", "io", nil)
+	c.BaseURL = srv.URL
+	err := c.NotificationsService.Stream(context.Background(), nil, func(ctx context.Context, ev Event) error { return nil })
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("err = %v, want ErrUnauthorized", err)
+	}
+}
@@ -0,0 +1,300 @@
+// Package transform converts between DNA Spaces floor coordinates and latitude/longitude.
+//
+// A Transform is derived per floor from the GPS markers in MapItemDetails.  With two markers a similarity transform
+// (scale, rotation and translation) is used.  With three or more an affine transform is fitted by least squares and
+// the root mean square error of the fit is reported.  Without markers, the floor's Latitude and Longitude are used as
+// the location of the floor's origin and the floor is assumed to be drawn with north at the top.
+//
+// Floor coordinates have their origin at the top left of the floor with y increasing downwards, as used by DNA Spaces.
+package transform
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/darrenparkinson/dnas"
+)
+
+// Kind represents the type of transform derived for a floor.
+type Kind string
+
+// Fields for Kind
+const (
+	Origin     Kind = "origin"
+	Similarity Kind = "similarity"
+	Affine     Kind = "affine"
+)
+
+// earthRadius is the mean radius of the earth in meters.
+const earthRadius = 6371008.8
+
+// Marker is a known point on a floor with its floor coordinates and geographic position.
+type Marker struct {
+	X         float64 `json:"x"`
+	Y         float64 `json:"y"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// Transform converts between floor coordinates and latitude/longitude for a single floor.
+type Transform struct {
+	Kind Kind
+
+	// Unit of the floor coordinates.
+	Unit dnas.Unit
+
+	// FitError is the root mean square distance in meters between the markers and their transformed positions.
+	// It is only non-zero when more than two markers were used.
+	FitError float64
+
+	// lat0 and lon0 are the origin of the local east/north plane, in degrees.
+	lat0, lon0 float64
+
+	// fwd maps floor meters (x, -y) to east/north meters: e = fwd[0]*x + fwd[1]*y + fwd[2], n = fwd[3]*x + fwd[4]*y + fwd[5].
+	fwd [6]float64
+	inv [6]float64
+}
+
+// ForFloor derives the transform for a floor from its map details.  The unit is that of the floor coordinates,
+// defaulting to feet if empty.
+func ForFloor(d dnas.MapItemDetails, unit dnas.Unit) (*Transform, error) {
+	markers, err := ParseMarkers(d.GpsMarkers)
+	if err != nil {
+		return nil, err
+	}
+	if len(markers) >= 2 {
+		return FromMarkers(markers, unit)
+	}
+	if d.Latitude == 0 && d.Longitude == 0 {
+		return nil, errors.New("transform: floor has no gps markers or location")
+	}
+	if unit == "" {
+		unit = dnas.UnitFeet
+	}
+	t := &Transform{Kind: Origin, Unit: unit, lat0: d.Latitude, lon0: d.Longitude}
+	ox, oy := unit.Convert(d.OffsetX, dnas.UnitMeters), unit.Convert(d.OffsetY, dnas.UnitMeters)
+	t.fwd = [6]float64{1, 0, -ox, 0, 1, oy}
+	if err := t.invert(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// FromMarkers derives a transform from two or more markers.  The unit is that of the marker floor coordinates,
+// defaulting to feet if empty.
+func FromMarkers(markers []Marker, unit dnas.Unit) (*Transform, error) {
+	if len(markers) < 2 {
+		return nil, errors.New("transform: at least two markers required")
+	}
+	if unit == "" {
+		unit = dnas.UnitFeet
+	}
+	t := &Transform{Unit: unit, lat0: markers[0].Latitude, lon0: markers[0].Longitude}
+
+	src := make([][2]float64, len(markers))
+	dst := make([][2]float64, len(markers))
+	for i, m := range markers {
+		src[i] = t.floorMeters(m.X, m.Y)
+		dst[i] = t.toPlane(m.Latitude, m.Longitude)
+	}
+
+	if len(markers) == 2 {
+		t.Kind = Similarity
+		// Treat the points as complex numbers and solve w = a*z + b.
+		z1, z2 := complex(src[0][0], src[0][1]), complex(src[1][0], src[1][1])
+		w1, w2 := complex(dst[0][0], dst[0][1]), complex(dst[1][0], dst[1][1])
+		if z1 == z2 {
+			return nil, errors.New("transform: markers must be at different floor positions")
+		}
+		a := (w2 - w1) / (z2 - z1)
+		b := w1 - a*z1
+		t.fwd = [6]float64{real(a), -imag(a), real(b), imag(a), real(a), imag(b)}
+	} else {
+		t.Kind = Affine
+		e, err := leastSquares(src, dst, 0)
+		if err != nil {
+			return nil, err
+		}
+		n, err := leastSquares(src, dst, 1)
+		if err != nil {
+			return nil, err
+		}
+		t.fwd = [6]float64{e[0], e[1], e[2], n[0], n[1], n[2]}
+		var sum float64
+		for i := range src {
+			pe, pn := t.apply(t.fwd, src[i][0], src[i][1])
+			sum += (pe-dst[i][0])*(pe-dst[i][0]) + (pn-dst[i][1])*(pn-dst[i][1])
+		}
+		t.FitError = math.Sqrt(sum / float64(len(src)))
+	}
+	if err := t.invert(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// ToGeo converts floor coordinates to latitude and longitude in degrees.
+func (t *Transform) ToGeo(x, y float64) (lat, lon float64) {
+	p := t.floorMeters(x, y)
+	e, n := t.apply(t.fwd, p[0], p[1])
+	return t.fromPlane(e, n)
+}
+
+// ToFloor converts latitude and longitude in degrees to floor coordinates.
+func (t *Transform) ToFloor(lat, lon float64) (x, y float64) {
+	p := t.toPlane(lat, lon)
+	mx, my := t.apply(t.inv, p[0], p[1])
+	return dnas.UnitMeters.Convert(mx, t.Unit), -dnas.UnitMeters.Convert(my, t.Unit)
+}
+
+// floorMeters converts floor coordinates to meters with y increasing upwards.
+func (t *Transform) floorMeters(x, y float64) [2]float64 {
+	return [2]float64{t.Unit.Convert(x, dnas.UnitMeters), -t.Unit.Convert(y, dnas.UnitMeters)}
+}
+
+// toPlane projects latitude and longitude onto a local east/north plane in meters around the origin.
+func (t *Transform) toPlane(lat, lon float64) [2]float64 {
+	rad := math.Pi / 180
+	return [2]float64{
+		(lon - t.lon0) * rad * earthRadius * math.Cos(t.lat0*rad),
+		(lat - t.lat0) * rad * earthRadius,
+	}
+}
+
+// fromPlane is the inverse of toPlane.
+func (t *Transform) fromPlane(e, n float64) (lat, lon float64) {
+	rad := math.Pi / 180
+	lat = t.lat0 + n/earthRadius/rad
+	lon = t.lon0 + e/(earthRadius*math.Cos(t.lat0*rad))/rad
+	return lat, lon
+}
+
+// apply applies the affine matrix m to the point.
+func (t *Transform) apply(m [6]float64, x, y float64) (float64, float64) {
+	return m[0]*x + m[1]*y + m[2], m[3]*x + m[4]*y + m[5]
+}
+
+// invert calculates the inverse of the forward matrix.
+func (t *Transform) invert() error {
+	a, b, c, d, e, f := t.fwd[0], t.fwd[1], t.fwd[2], t.fwd[3], t.fwd[4], t.fwd[5]
+	det := a*e - b*d
+	if math.Abs(det) < 1e-12 {
+		return errors.New("transform: transform is not invertible, check the markers are not in a line")
+	}
+	t.inv = [6]float64{
+		e / det, -b / det, (b*f - c*e) / det,
+		-d / det, a / det, (c*d - a*f) / det,
+	}
+	return nil
+}
+
+// leastSquares fits v = p[0]*x + p[1]*y + p[2] to the given component of dst by solving the normal equations.
+func leastSquares(src, dst [][2]float64, component int) ([3]float64, error) {
+	var m [3][4]float64
+	for i := range src {
+		row := [3]float64{src[i][0], src[i][1], 1}
+		for r := 0; r < 3; r++ {
+			for c := 0; c < 3; c++ {
+				m[r][c] += row[r] * row[c]
+			}
+			m[r][3] += row[r] * dst[i][component]
+		}
+	}
+	// Gaussian elimination with partial pivoting.
+	for col := 0; col < 3; col++ {
+		pivot := col
+		for r := col + 1; r < 3; r++ {
+			if math.Abs(m[r][col]) > math.Abs(m[pivot][col]) {
+				pivot = r
+			}
+		}
+		if math.Abs(m[pivot][col]) < 1e-12 {
+			return [3]float64{}, errors.New("transform: markers must not all be in a line")
+		}
+		m[col], m[pivot] = m[pivot], m[col]
+		for r := 0; r < 3; r++ {
+			if r == col {
+				continue
+			}
+			f := m[r][col] / m[col][col]
+			for c := col; c < 4; c++ {
+				m[r][c] -= f * m[col][c]
+			}
+		}
+	}
+	return [3]float64{m[0][3] / m[0][0], m[1][3] / m[1][1], m[2][3] / m[2][2]}, nil
+}
+
+// ParseMarkers parses the GpsMarkers of a floor.  Each marker may be a JSON object with x and y floor coordinates and
+// latitude and longitude, or a list of four numbers in the order x, y, latitude, longitude separated by commas,
+// semicolons or spaces.  In JSON, lat, lng and lon are also accepted, with the full names taking precedence.
+func ParseMarkers(markers []string) ([]Marker, error) {
+	var result []Marker
+	for _, s := range markers {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		m, err := parseMarker(s)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, m)
+	}
+	return result, nil
+}
+
+// parseMarker parses a single marker.
+func parseMarker(s string) (Marker, error) {
+	if strings.HasPrefix(s, "{") {
+		var raw map[string]float64
+		if err := json.Unmarshal([]byte(s), &raw); err != nil {
+			return Marker{}, fmt.Errorf("transform: invalid gps marker %q: %w", s, err)
+		}
+		names := make([]string, 0, len(raw))
+		for k := range raw {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		// get returns the value of the first of the keys present, preferring an exact match to one differing in case.
+		get := func(keys ...string) (float64, bool) {
+			for _, key := range keys {
+				if v, ok := raw[key]; ok {
+					return v, true
+				}
+				for _, k := range names {
+					if strings.EqualFold(k, key) {
+						return raw[k], true
+					}
+				}
+			}
+			return 0, false
+		}
+		x, okX := get("x", "xPos")
+		y, okY := get("y", "yPos")
+		lat, okLat := get("latitude", "lat")
+		lon, okLon := get("longitude", "lng", "lon", "long")
+		if !okX || !okY || !okLat || !okLon {
+			return Marker{}, fmt.Errorf("transform: gps marker %q must have x, y, latitude and longitude", s)
+		}
+		return Marker{X: x, Y: y, Latitude: lat, Longitude: lon}, nil
+	}
+	fields := strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ';' || r == ' ' || r == '\t' })
+	if len(fields) != 4 {
+		return Marker{}, fmt.Errorf("transform: invalid gps marker %q", s)
+	}
+	var v [4]float64
+	for i, f := range fields {
+		n, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return Marker{}, fmt.Errorf("transform: invalid gps marker %q: %w", s, err)
+		}
+		v[i] = n
+	}
+	return Marker{X: v[0], Y: v[1], Latitude: v[2], Longitude: v[3]}, nil
+}
@@ -0,0 +1,142 @@
+package transform
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/darrenparkinson/dnas"
+)
+
+const (
+	lat0 = 51.5
+	lon0 = -0.12
+)
+
+// geo returns the position of a floor point in feet on a floor placed at lat0, lon0, rotated by angle degrees
+// anticlockwise and scaled by scale, using the same local plane as Transform.
+func geo(x, y, angle, scale float64) (lat, lon float64) {
+	mx, my := x*0.3048*scale, -y*0.3048*scale
+	a := angle * math.Pi / 180
+	e, n := mx*math.Cos(a)-my*math.Sin(a), mx*math.Sin(a)+my*math.Cos(a)
+	t := &Transform{lat0: lat0, lon0: lon0}
+	return t.fromPlane(e, n)
+}
+
+func marker(x, y, angle, scale float64) Marker {
+	lat, lon := geo(x, y, angle, scale)
+	return Marker{X: x, Y: y, Latitude: lat, Longitude: lon}
+}
+
+// checkPoints checks that the transform maps floor points to the expected positions and back.
+func checkPoints(t *testing.T, tr *Transform, angle, scale float64) {
+	t.Helper()
+	for _, p := range [][2]float64{{0, 0}, {50, 25}, {200, -80}, {-30, 400}} {
+		lat, lon := tr.ToGeo(p[0], p[1])
+		wantLat, wantLon := geo(p[0], p[1], angle, scale)
+		if math.Abs(lat-wantLat) > 1e-9 || math.Abs(lon-wantLon) > 1e-9 {
+			t.Errorf("ToGeo(%v) = %v, %v, want %v, %v", p, lat, lon, wantLat, wantLon)
+		}
+		x, y := tr.ToFloor(lat, lon)
+		if math.Abs(x-p[0]) > 1e-6 || math.Abs(y-p[1]) > 1e-6 {
+			t.Errorf("ToFloor(ToGeo(%v)) = %v, %v", p, x, y)
+		}
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	tr, err := FromMarkers([]Marker{marker(0, 0, 30, 1.1), marker(100, 20, 30, 1.1)}, dnas.UnitFeet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.Kind != Similarity || tr.FitError != 0 {
+		t.Errorf("kind %s with fit error %v, want similarity", tr.Kind, tr.FitError)
+	}
+	checkPoints(t, tr, 30, 1.1)
+
+	if _, err := FromMarkers([]Marker{marker(5, 5, 0, 1), marker(5, 5, 0, 1)}, ""); err == nil {
+		t.Error("expected error for markers at the same position")
+	}
+}
+
+func TestAffine(t *testing.T) {
+	markers := []Marker{marker(0, 0, -45, 1), marker(100, 0, -45, 1), marker(0, 100, -45, 1), marker(100, 100, -45, 1)}
+	tr, err := FromMarkers(markers, dnas.UnitFeet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.Kind != Affine || tr.FitError > 1e-6 {
+		t.Errorf("kind %s with fit error %v, want an exact affine fit", tr.Kind, tr.FitError)
+	}
+	checkPoints(t, tr, -45, 1)
+
+	// Moving one marker of a square 1 metre north leaves residuals of 0.25 metres at every marker.
+	markers[3].Latitude += 1 / earthRadius * 180 / math.Pi
+	tr, err = FromMarkers(markers, dnas.UnitFeet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(tr.FitError-0.25) > 1e-6 {
+		t.Errorf("fit error = %v, want 0.25", tr.FitError)
+	}
+
+	if _, err := FromMarkers([]Marker{marker(0, 0, 0, 1), marker(10, 10, 0, 1), marker(20, 20, 0, 1)}, ""); err == nil {
+		t.Error("expected error for markers in a line")
+	}
+}
+
+func TestOrigin(t *testing.T) {
+	d := dnas.MapItemDetails{Latitude: lat0, Longitude: lon0, OffsetX: 10, OffsetY: 20}
+	tr, err := ForFloor(d, dnas.UnitMeters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.Kind != Origin {
+		t.Errorf("kind = %s, want origin", tr.Kind)
+	}
+	// The floor's offset is at its location, with north at the top.
+	if lat, lon := tr.ToGeo(10, 20); math.Abs(lat-lat0) > 1e-12 || math.Abs(lon-lon0) > 1e-12 {
+		t.Errorf("ToGeo(offset) = %v, %v, want the floor location", lat, lon)
+	}
+	lat, lon := tr.ToGeo(110, 20)
+	if lat != lat0 || math.Abs((lon-lon0)*math.Pi/180*earthRadius*math.Cos(lat0*math.Pi/180)-100) > 1e-6 {
+		t.Errorf("ToGeo(100m east) = %v, %v", lat, lon)
+	}
+	if lat, _ := tr.ToGeo(10, 120); lat >= lat0 {
+		t.Errorf("ToGeo(100m down) = %v, want south of the location", lat)
+	}
+
+	if _, err := ForFloor(dnas.MapItemDetails{}, ""); err == nil {
+		t.Error("expected error for a floor without markers or location")
+	}
+}
+
+func TestParseMarkers(t *testing.T) {
+	markers, err := ParseMarkers([]string{
+		`{"x":1,"y":2,"latitude":3,"longitude":4}`,
+		`{"xPos":1,"yPos":2,"lat":3,"lng":4}`,
+		`{"X":1,"Y":2,"lat":30,"Latitude":3,"lon":40,"longitude":4}`,
+		`{"x":1,"y":2,"Lat":30,"latitude":3,"LONG":4}`,
+		"1, 2, 3, 4",
+		"1;2;3;4",
+		"  ",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(markers) != 6 {
+		t.Fatalf("parsed %d markers, want 6", len(markers))
+	}
+	want := Marker{X: 1, Y: 2, Latitude: 3, Longitude: 4}
+	for i, m := range markers {
+		if m != want {
+			t.Errorf("marker %d = %+v, want %+v", i, m, want)
+		}
+	}
+
+	for _, bad := range []string{`{"x":1,"y":2,"lat":3}`, `{"x":"1"}`, "1,2,3", "1,2,a,4"} {
+		if _, err := ParseMarkers([]string{bad}); err == nil || !strings.HasPrefix(err.Error(), "transform:") {
+			t.Errorf("ParseMarkers(%q) err = %v, want an error", bad, err)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package dnas
+
+import "strings"
+
+// Unit represents the unit of length used for map coordinates.
+type Unit string
+
+// Fields for Unit
+const (
+	UnitFeet   Unit = "FEET"
+	UnitMeters Unit = "METERS"
+)
+
+// metersPerFoot is the length of an international foot in meters.
+const metersPerFoot = 0.3048
+
+// ParseUnit returns the Unit for the given name, accepting common spellings and abbreviations, e.g. "ft", "metres" or "m".
+// An empty string is returned for names that are not recognised.
+func ParseUnit(name string) Unit {
+	switch strings.ToUpper(strings.TrimSpace(name)) {
+	case "FEET", "FOOT", "FT":
+		return UnitFeet
+	case "METERS", "METER", "METRES", "METRE", "M":
+		return UnitMeters
+	}
+	return ""
+}
+
+// Convert converts the length v from u to the unit given.
+// If either unit is not recognised, v is returned unchanged.
+func (u Unit) Convert(v float64, to Unit) float64 {
+	from, to := ParseUnit(string(u)), ParseUnit(string(to))
+	switch {
+	case from == UnitFeet && to == UnitMeters:
+		return v * metersPerFoot
+	case from == UnitMeters && to == UnitFeet:
+		return v / metersPerFoot
+	}
+	return v
+}
+
+// In returns the corner converted to the given unit.
+// If the corner has no unit it is assumed to already be in that unit.
+func (c MapItemCorner) In(unit Unit) MapItemCorner {
+	if c.Unit == "" {
+		c.Unit = string(unit)
+		return c
+	}
+	from := Unit(c.Unit)
+	return MapItemCorner{
+		X:    from.Convert(c.X, unit),
+		Y:    from.Convert(c.Y, unit),
+		Z:    from.Convert(c.Z, unit),
+		Unit: string(unit),
+	}
+}
@@ -0,0 +1,238 @@
+package dnas
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event types synthesized by a Watcher from polled snapshots of the active clients.  Devices arriving and
+// leaving are reported as EventDeviceEntry and EventDeviceExit.
+const (
+	EventFloorChanged       EventType = "DEVICE_FLOOR_CHANGED"
+	EventAPChanged          EventType = "DEVICE_AP_CHANGED"
+	EventAssociationChanged EventType = "DEVICE_ASSOCIATION_CHANGED"
+)
+
+// DefaultWatchInterval is the time between polls of a Watcher when none is given.
+const DefaultWatchInterval = 30 * time.Second
+
+// Watcher polls the active clients and compares consecutive snapshots by mac address, creating DeviceEvents for
+// tenants that can't use notifications.  Each event has the device's current state in Device and its state at the
+// previous event in Previous.  For EventDeviceEntry Previous is nil, and for EventDeviceExit both are the last
+// state seen.
+//
+// Hysteresis stops devices that briefly drop out of, or reappear in, the results from flapping: a device must be seen in
+// ArrivalPolls consecutive polls to arrive, and missing from DeparturePolls consecutive polls to leave.  Likewise a
+// change of floor, access point or association must be seen in ChangePolls consecutive polls.
+type Watcher struct {
+	// Interval is the time between polls.  If zero, DefaultWatchInterval is used.
+	Interval time.Duration
+
+	// Parameters limit the clients watched, e.g. to a floor.  The Page parameter is ignored.
+	Parameters *ClientParameters
+
+	// ArrivalPolls, DeparturePolls and ChangePolls control the hysteresis.  If zero, 1, 3 and 1 are used.
+	ArrivalPolls   int
+	DeparturePolls int
+	ChangePolls    int
+
+	// ErrorLog is used to log polling and handler errors in Run.  If nil, they are not logged.
+	ErrorLog *log.Logger
+
+	s *ActiveClientsService
+
+	mu      sync.Mutex
+	devices map[string]*watchedDevice
+}
+
+// watchedDevice is the state a Watcher keeps for each device.
+type watchedDevice struct {
+	present  bool
+	last     LocationDevice
+	reported LocationDevice
+	seen     int
+	missed   int
+
+	// Consecutive polls in which each attribute has differed from the reported state.
+	floorPolls, apPolls, assocPolls int
+}
+
+// NewWatcher returns a Watcher polling the active clients at the given interval.
+func NewWatcher(s *ActiveClientsService, interval time.Duration) *Watcher {
+	return &Watcher{s: s, Interval: interval}
+}
+
+// Run polls until the context is done, calling fn for each event.  Errors from polling and from fn are logged,
+// and do not stop the Watcher.
+func (w *Watcher) Run(ctx context.Context, fn EventHandler) error {
+	interval := w.Interval
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		events, err := w.Poll(ctx)
+		if err != nil && ctx.Err() == nil {
+			w.logf("dnas: watcher: %v", err)
+		}
+		for _, ev := range events {
+			if err := fn(ctx, ev); err != nil {
+				w.logf("dnas: watcher: handling event %s: %v", ev.Header().ID, err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll retrieves every page of active clients and returns the events since the previous poll.
+// If the clients can't be retrieved the error is returned and the state is left unchanged.
+func (w *Watcher) Poll(ctx context.Context) ([]Event, error) {
+	devices, err := w.s.ListAllClients(ctx, w.Parameters)
+	if err != nil {
+		return nil, err
+	}
+	return w.Observe(devices, time.Now()), nil
+}
+
+// Observe compares a snapshot of the active clients, taken at the given time, with the previous snapshot
+// and returns the resulting events.  It can be used instead of Poll to supply the snapshots yourself.
+func (w *Watcher) Observe(devices []LocationDevice, at time.Time) []Event {
+	arrival, departure, change := w.ArrivalPolls, w.DeparturePolls, w.ChangePolls
+	if arrival <= 0 {
+		arrival = 1
+	}
+	if departure <= 0 {
+		departure = 3
+	}
+	if change <= 0 {
+		change = 1
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.devices == nil {
+		w.devices = make(map[string]*watchedDevice)
+	}
+
+	var events []Event
+	emit := func(t EventType, d LocationDevice, previous *LocationDevice) {
+		ts := timeToMillis(at)
+		events = append(events, &DeviceEvent{
+			EventHeader: EventHeader{ID: fmt.Sprintf("%s-%s-%d", t, d.MacAddress, ts), Type: t, Timestamp: ts},
+			Device:      d,
+			Previous:    previous,
+		})
+	}
+
+	seen := make(map[string]bool, len(devices))
+	for _, d := range devices {
+		mac := strings.ToLower(d.MacAddress)
+		if mac == "" || seen[mac] {
+			continue
+		}
+		seen[mac] = true
+		st, ok := w.devices[mac]
+		if !ok {
+			st = &watchedDevice{}
+			w.devices[mac] = st
+		}
+		st.last = d
+		st.missed = 0
+		if !st.present {
+			st.seen++
+			if st.seen >= arrival {
+				st.present = true
+				st.reported = d
+				emit(EventDeviceEntry, d, nil)
+			}
+			continue
+		}
+
+		st.floorPolls = countChange(st.floorPolls, d.FloorID != st.reported.FloorID)
+		st.apPolls = countChange(st.apPolls, !strings.EqualFold(d.ApMacAddress, st.reported.ApMacAddress))
+		st.assocPolls = countChange(st.assocPolls, d.Associated != st.reported.Associated)
+		if st.floorPolls >= change {
+			previous := st.reported
+			st.reported.FloorID = d.FloorID
+			st.floorPolls = 0
+			emit(EventFloorChanged, d, &previous)
+		}
+		if st.apPolls >= change {
+			previous := st.reported
+			st.reported.ApMacAddress = d.ApMacAddress
+			st.apPolls = 0
+			emit(EventAPChanged, d, &previous)
+		}
+		if st.assocPolls >= change {
+			previous := st.reported
+			st.reported.Associated = d.Associated
+			st.assocPolls = 0
+			emit(EventAssociationChanged, d, &previous)
+		}
+		// Keep the rest of the reported state current so Previous reflects the latest position.
+		floor, ap, assoc := st.reported.FloorID, st.reported.ApMacAddress, st.reported.Associated
+		st.reported = d
+		st.reported.FloorID, st.reported.ApMacAddress, st.reported.Associated = floor, ap, assoc
+	}
+
+	var missing []string
+	for mac := range w.devices {
+		if !seen[mac] {
+			missing = append(missing, mac)
+		}
+	}
+	sort.Strings(missing)
+	for _, mac := range missing {
+		st := w.devices[mac]
+		if !st.present {
+			delete(w.devices, mac)
+			continue
+		}
+		st.missed++
+		if st.missed >= departure {
+			last := st.last
+			emit(EventDeviceExit, last, &last)
+			delete(w.devices, mac)
+		}
+	}
+	return events
+}
+
+// countChange returns the number of consecutive polls an attribute has differed.
+func countChange(polls int, changed bool) int {
+	if changed {
+		return polls + 1
+	}
+	return 0
+}
+
+// Present returns the mac addresses, in lower case and sorted, of the devices currently considered present.
+func (w *Watcher) Present() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var macs []string
+	for mac, st := range w.devices {
+		if st.present {
+			macs = append(macs, mac)
+		}
+	}
+	sort.Strings(macs)
+	return macs
+}
+
+// logf logs to ErrorLog, if set.
+func (w *Watcher) logf(format string, args ...interface{}) {
+	if w.ErrorLog != nil {
+		w.ErrorLog.Printf(format, args...)
+	}
+}
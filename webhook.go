@@ -0,0 +1,329 @@
+package dnas
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultSignatureHeader is the header checked for the payload signature when a WebhookHandler has a Secret.
+const DefaultSignatureHeader = "X-Dnas-Signature"
+
+// DefaultMaxWebhookBody is the largest notification body accepted by a WebhookHandler when none is given.
+const DefaultMaxWebhookBody = 10 << 20
+
+// Event is a notification received from DNA Spaces.
+// It is one of *DeviceEvent, *APEvent or, for event types the library doesn't know, *UnknownEvent.
+type Event interface {
+	Header() EventHeader
+}
+
+// EventHeader contains the fields common to every event.
+type EventHeader struct {
+	ID             string    `json:"eventId"`
+	Type           EventType `json:"eventType"`
+	SubscriptionID string    `json:"subscriptionId,omitempty"`
+
+	// Timestamp is the UTC time of the event in milliseconds.
+	Timestamp int64 `json:"timestamp"`
+}
+
+// Header returns the event header, so that every event type implements Event.
+func (h EventHeader) Header() EventHeader { return h }
+
+// Time returns the Timestamp as a time.Time.
+func (h EventHeader) Time() time.Time { return millisToTime(h.Timestamp) }
+
+// DeviceEvent is sent for EventDeviceLocationUpdate, EventDeviceEntry and EventDeviceExit, and is created by a
+// Watcher for the event types it synthesizes.  The device uses the same format as the Active Clients API.
+type DeviceEvent struct {
+	EventHeader
+	Device LocationDevice `json:"device"`
+
+	// Previous is the state of the device before the change, for events created by a Watcher.
+	Previous *LocationDevice `json:"previous,omitempty"`
+}
+
+// APEvent is sent for EventAPUp and EventAPDown.
+type APEvent struct {
+	EventHeader
+	AccessPoint AccessPointState `json:"accessPoint"`
+}
+
+// AccessPointState describes the access point in an APEvent.
+type AccessPointState struct {
+	ApMac      string `json:"apMac"`
+	Name       string `json:"name,omitempty"`
+	Status     string `json:"status,omitempty"`
+	CampusID   string `json:"campusId,omitempty"`
+	BuildingID string `json:"buildingId,omitempty"`
+	FloorID    string `json:"floorId,omitempty"`
+}
+
+// UnknownEvent holds an event of a type the library doesn't decode, with the original JSON in Raw.
+type UnknownEvent struct {
+	EventHeader
+	Raw json.RawMessage `json:"-"`
+}
+
+// DecodeEvents decodes a notification payload.  DNA Spaces may send a single event, an array of events,
+// or an object with the events in an "events" array, and all three are accepted.
+func DecodeEvents(data []byte) ([]Event, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, errors.New("dnas: empty event payload")
+	}
+	var raws []json.RawMessage
+	switch data[0] {
+	case '[':
+		if err := json.Unmarshal(data, &raws); err != nil {
+			return nil, err
+		}
+	case '{':
+		var batch struct {
+			Events []json.RawMessage `json:"events"`
+		}
+		if err := json.Unmarshal(data, &batch); err != nil {
+			return nil, err
+		}
+		if batch.Events != nil {
+			raws = batch.Events
+		} else {
+			raws = []json.RawMessage{data}
+		}
+	default:
+		return nil, errors.New("dnas: event payload must be a JSON object or array")
+	}
+	events := make([]Event, 0, len(raws))
+	for _, raw := range raws {
+		ev, err := decodeEvent(raw)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, ev)
+	}
+	return events, nil
+}
+
+// decodeEvent decodes a single event into the struct for its type.
+func decodeEvent(raw json.RawMessage) (Event, error) {
+	var h EventHeader
+	if err := json.Unmarshal(raw, &h); err != nil {
+		return nil, err
+	}
+	if h.Type == "" {
+		return nil, errors.New("dnas: event type missing")
+	}
+	switch h.Type {
+	case EventDeviceLocationUpdate, EventDeviceEntry, EventDeviceExit,
+		EventFloorChanged, EventAPChanged, EventAssociationChanged:
+		ev := &DeviceEvent{}
+		if err := json.Unmarshal(raw, ev); err != nil {
+			return nil, err
+		}
+		return ev, nil
+	case EventAPUp, EventAPDown:
+		ev := &APEvent{}
+		if err := json.Unmarshal(raw, ev); err != nil {
+			return nil, err
+		}
+		return ev, nil
+	}
+	return &UnknownEvent{EventHeader: h, Raw: append(json.RawMessage(nil), raw...)}, nil
+}
+
+// EventHandler is called for each event received.  Returning an error causes the notification to be
+// answered with a 500 status so that DNA Spaces will retry it.
+type EventHandler func(ctx context.Context, ev Event) error
+
+// WebhookHandler is an http.Handler that receives notifications from DNA Spaces and dispatches each event
+// to the handlers registered for its type.  Requests that are not POST are rejected with 405, requests that
+// fail verification with 401, and payloads that can't be decoded with 400.  Panics in handlers are recovered
+// and answered with 500.
+type WebhookHandler struct {
+	// Secret, if set, is the key used to verify the HMAC-SHA256 signature of the body, given in hex
+	// in SignatureHeader, optionally prefixed with "sha256=".
+	Secret string
+
+	// SignatureHeader is the header containing the signature.  If empty, DefaultSignatureHeader is used.
+	SignatureHeader string
+
+	// Token, if set, must be given in the Authorization header, either on its own or as a bearer token.
+	// The scheme is matched case insensitively.
+	// Use this when the subscription target is configured with a shared secret header rather than a signature.
+	Token string
+
+	// MaxBodySize limits the size of the notification body.  If zero, DefaultMaxWebhookBody is used.
+	MaxBodySize int64
+
+	// Queue, if set, persists the events before the notification is acknowledged, rather than calling the
+	// handlers directly.  Use Queue.Run with Deliver to pass the events to the handlers.
+	Queue *EventQueue
+
+	// ErrorLog is used to log handler errors and panics.  If nil, they are not logged.
+	ErrorLog *log.Logger
+
+	mu       sync.RWMutex
+	handlers map[EventType][]EventHandler
+	all      []EventHandler
+}
+
+// NewWebhookHandler returns a WebhookHandler verifying signatures with the given secret.
+// Use an empty secret to disable signature verification.
+func NewWebhookHandler(secret string) *WebhookHandler {
+	return &WebhookHandler{Secret: secret}
+}
+
+// Handle registers fn to be called for events of the given type.
+func (h *WebhookHandler) Handle(t EventType, fn EventHandler) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.handlers == nil {
+		h.handlers = make(map[EventType][]EventHandler)
+	}
+	h.handlers[t] = append(h.handlers[t], fn)
+}
+
+// HandleAll registers fn to be called for every event, after any handlers for the event's type.
+func (h *WebhookHandler) HandleAll(fn EventHandler) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.all = append(h.all, fn)
+}
+
+// ServeHTTP implements http.Handler.
+func (h *WebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	limit := h.MaxBodySize
+	if limit <= 0 {
+		limit = DefaultMaxWebhookBody
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil {
+		http.Error(w, "unable to read body", http.StatusBadRequest)
+		return
+	}
+	if int64(len(body)) > limit {
+		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if !h.verify(r, body) {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	events, err := DecodeEvents(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if h.Queue != nil {
+		if _, err := h.Queue.Append(events); err != nil {
+			h.logf("dnas: webhook: %v", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err := h.Dispatch(r.Context(), events); err != nil {
+		h.logf("dnas: webhook: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Dispatch calls the registered handlers for each event in order.  Every event is dispatched even if a
+// handler fails, and the first error is returned.  Handlers are called without any lock held, so they may
+// register further handlers, which apply from the next event.
+func (h *WebhookHandler) Dispatch(ctx context.Context, events []Event) error {
+	var first error
+	for _, ev := range events {
+		h.mu.RLock()
+		typed := append([]EventHandler(nil), h.handlers[ev.Header().Type]...)
+		all := append([]EventHandler(nil), h.all...)
+		h.mu.RUnlock()
+		for _, fns := range [][]EventHandler{typed, all} {
+			for _, fn := range fns {
+				if err := h.call(ctx, fn, ev); err != nil && first == nil {
+					first = err
+				}
+			}
+		}
+	}
+	return first
+}
+
+// Deliver calls the registered handlers for a single event, and can be used as the EventHandler for Queue.Run.
+func (h *WebhookHandler) Deliver(ctx context.Context, ev Event) error {
+	return h.Dispatch(ctx, []Event{ev})
+}
+
+// call runs the handler, converting a panic into an error.
+func (h *WebhookHandler) call(ctx context.Context, fn EventHandler, ev Event) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			h.logf("dnas: webhook: panic handling event %s: %v\n%s", ev.Header().ID, p, debug.Stack())
+			err = fmt.Errorf("dnas: panic handling event %s: %v", ev.Header().ID, p)
+		}
+	}()
+	return fn(ctx, ev)
+}
+
+// verify checks the token and signature, if configured.
+func (h *WebhookHandler) verify(r *http.Request, body []byte) bool {
+	if h.Token != "" {
+		got := strings.TrimSpace(r.Header.Get("Authorization"))
+		if i := strings.IndexByte(got, ' '); i >= 0 && strings.EqualFold(got[:i], "Bearer") {
+			got = strings.TrimSpace(got[i+1:])
+		}
+		if subtle.ConstantTimeCompare([]byte(got), []byte(h.Token)) != 1 {
+			return false
+		}
+	}
+	if h.Secret != "" {
+		header := h.SignatureHeader
+		if header == "" {
+			header = DefaultSignatureHeader
+		}
+		got, err := hex.DecodeString(strings.TrimPrefix(r.Header.Get(header), "sha256="))
+		if err != nil {
+			return false
+		}
+		return hmac.Equal(got, SignPayload(h.Secret, body))
+	}
+	return true
+}
+
+// SignPayload returns the HMAC-SHA256 of the body using the secret, as checked by WebhookHandler.
+// It is useful for testing receivers, or for forwarding notifications to another WebhookHandler.
+func SignPayload(secret string, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// logf logs to ErrorLog, if set.
+func (h *WebhookHandler) logf(format string, args ...interface{}) {
+	if h.ErrorLog != nil {
+		h.ErrorLog.Printf(format, args...)
+	}
+}
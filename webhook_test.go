@@ -0,0 +1,149 @@
+package dnas
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testPayload = `[{"eventId":"1","eventType":"DEVICE_ENTRY","timestamp":1,"device":{"macAddress":"a"}},` +
+	`{"eventId":"2","eventType":"AP_DOWN","timestamp":2,"accessPoint":{"apMac":"b"}}]`
+
+// postWebhook sends the body to the handler with the given headers and returns the response status.
+func postWebhook(h http.Handler, method, body string, header map[string]string) int {
+	r := httptest.NewRequest(method, "/", strings.NewReader(body))
+	for k, v := range header {
+		r.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestWebhookDispatch(t *testing.T) {
+	h := NewWebhookHandler("")
+	var got []string
+	h.Handle(EventDeviceEntry, func(ctx context.Context, ev Event) error {
+		got = append(got, "entry:"+ev.(*DeviceEvent).Device.MacAddress)
+		return nil
+	})
+	h.HandleAll(func(ctx context.Context, ev Event) error {
+		got = append(got, "all:"+ev.Header().ID)
+		return nil
+	})
+	if code := postWebhook(h, "POST", testPayload, nil); code != http.StatusNoContent {
+		t.Fatalf("status = %d, want 204", code)
+	}
+	want := []string{"entry:a", "all:1", "all:2"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("handled %q, want %q", got, want)
+	}
+}
+
+func TestWebhookRejects(t *testing.T) {
+	h := NewWebhookHandler("")
+	h.MaxBodySize = 20
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		want   int
+	}{
+		{"method", "GET", "", http.StatusMethodNotAllowed},
+		{"too large", "POST", strings.Repeat(" ", 21), http.StatusRequestEntityTooLarge},
+		{"invalid", "POST", `{"eventId":`, http.StatusBadRequest},
+		{"empty", "POST", "", http.StatusBadRequest},
+		{"not json", "POST", "events", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if code := postWebhook(h, tt.method, tt.body, nil); code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, code, tt.want)
+		}
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	h := NewWebhookHandler("s3cret")
+	h.HandleAll(func(ctx context.Context, ev Event) error { return nil })
+	sig := hex.EncodeToString(SignPayload("s3cret", []byte(testPayload)))
+	tests := []struct {
+		name   string
+		header map[string]string
+		want   int
+	}{
+		{"valid", map[string]string{DefaultSignatureHeader: sig}, http.StatusNoContent},
+		{"prefixed", map[string]string{DefaultSignatureHeader: "sha256=" + sig}, http.StatusNoContent},
+		{"missing", nil, http.StatusUnauthorized},
+		{"wrong", map[string]string{DefaultSignatureHeader: hex.EncodeToString(SignPayload("other", []byte(testPayload)))}, http.StatusUnauthorized},
+		{"not hex", map[string]string{DefaultSignatureHeader: "zz"}, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		if code := postWebhook(h, "POST", testPayload, tt.header); code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, code, tt.want)
+		}
+	}
+
+	h.SignatureHeader = "X-Signature"
+	if code := postWebhook(h, "POST", testPayload, map[string]string{"X-Signature": sig}); code != http.StatusNoContent {
+		t.Errorf("custom header: status = %d, want 204", code)
+	}
+}
+
+func TestWebhookToken(t *testing.T) {
+	h := NewWebhookHandler("")
+	h.Token = "t0ken"
+	tests := []struct {
+		auth string
+		want int
+	}{
+		{"t0ken", http.StatusNoContent},
+		{"Bearer t0ken", http.StatusNoContent},
+		{"bearer t0ken", http.StatusNoContent},
+		{"BEARER  t0ken", http.StatusNoContent},
+		{"Bearer other", http.StatusUnauthorized},
+		{"Basic t0ken", http.StatusUnauthorized},
+		{"", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		if code := postWebhook(h, "POST", testPayload, map[string]string{"Authorization": tt.auth}); code != tt.want {
+			t.Errorf("Authorization %q: status = %d, want %d", tt.auth, code, tt.want)
+		}
+	}
+}
+
+func TestWebhookHandlerFailures(t *testing.T) {
+	var logged bytes.Buffer
+	h := NewWebhookHandler("")
+	h.ErrorLog = log.New(&logged, "", 0)
+	calls := 0
+	h.Handle(EventDeviceEntry, func(ctx context.Context, ev Event) error {
+		panic("boom")
+	})
+	h.Handle(EventAPDown, func(ctx context.Context, ev Event) error {
+		return errors.New("failed")
+	})
+	h.HandleAll(func(ctx context.Context, ev Event) error {
+		calls++
+		return nil
+	})
+	if code := postWebhook(h, "POST", testPayload, nil); code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", code)
+	}
+	if calls != 2 {
+		t.Errorf("HandleAll called %d times, want every event dispatched", calls)
+	}
+	if !strings.Contains(logged.String(), "boom") {
+		t.Errorf("log = %q, want the panic", logged.String())
+	}
+
+	err := h.Deliver(context.Background(), &DeviceEvent{EventHeader: EventHeader{ID: "3", Type: EventDeviceEntry}})
+	if err == nil || !strings.Contains(err.Error(), "panic") {
+		t.Errorf("Deliver err = %v, want the recovered panic", err)
+	}
+}
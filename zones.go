@@ -0,0 +1,302 @@
+package dnas
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ZoneEventType represents the type of event emitted by a ZoneTracker
+type ZoneEventType string
+
+// Fields for ZoneEventType
+const (
+	ZoneEntered   ZoneEventType = "ZONE_ENTERED"
+	ZoneExited    ZoneEventType = "ZONE_EXITED"
+	DwellExceeded ZoneEventType = "DWELL_EXCEEDED"
+)
+
+// Zone is a locally defined polygon on a floor.
+// Vertices are in the floor's coordinate units, the same as device coordinates, unless they give their own unit.
+type Zone struct {
+	ID       string          `json:"id" yaml:"id"`
+	Name     string          `json:"name,omitempty" yaml:"name,omitempty"`
+	FloorID  string          `json:"floorId" yaml:"floorId"`
+	Vertices []MapItemCorner `json:"vertices" yaml:"vertices"`
+
+	// DwellThreshold, if set, causes a DwellExceeded event once a device has been in the zone for longer than this.
+	DwellThreshold time.Duration `json:"-" yaml:"-"`
+}
+
+// zoneFile is the on-disk representation of a Zone, allowing the dwell threshold to be given as a duration string, e.g. "10m".
+type zoneFile struct {
+	Zone           `yaml:",inline"`
+	DwellThreshold string `yaml:"dwellThreshold,omitempty"`
+}
+
+// ZoneEvent is emitted by a ZoneTracker when a device enters or exits a zone, or stays too long.
+type ZoneEvent struct {
+	Type       ZoneEventType `json:"type"`
+	ZoneID     string        `json:"zoneId"`
+	ZoneName   string        `json:"zoneName,omitempty"`
+	FloorID    string        `json:"floorId"`
+	MacAddress string        `json:"macAddress"`
+	Timestamp  time.Time     `json:"timestamp"`
+
+	// Dwell is the time the device has spent in the zone.  It is zero for ZoneEntered events.
+	Dwell time.Duration `json:"dwell,omitempty"`
+}
+
+// Polygon returns the vertices of the zone converted to the given unit.
+func (z Zone) Polygon(unit Unit) []MapItemCorner {
+	poly := make([]MapItemCorner, len(z.Vertices))
+	for i, c := range z.Vertices {
+		poly[i] = c.In(unit)
+	}
+	return poly
+}
+
+// Contains reports whether the point, given in the unit provided, is inside the zone.  Vertices without a unit are
+// taken to be in the same unit as the point.
+func (z Zone) Contains(x, y float64, unit Unit) bool {
+	return pointInPolygon(x, y, z.Polygon(unit))
+}
+
+// LoadZones reads zone definitions as JSON or YAML, either as an array of zones or an object with a "zones" array.
+// YAML uses the same field names as JSON.  Each zone must have a unique ID, and vertices may only give a unit that
+// ParseUnit recognises.
+func LoadZones(r io.Reader) ([]Zone, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	// JSON is valid YAML, so both are decoded as YAML.  Scalars such as an id of 1 are kept as written.
+	var raw []zoneFile
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		var wrapped struct {
+			Zones []zoneFile `yaml:"zones"`
+		}
+		if err := yaml.Unmarshal(data, &wrapped); err != nil {
+			return nil, fmt.Errorf("dnas: invalid zone definitions: %w", err)
+		}
+		raw = wrapped.Zones
+	}
+	zones := make([]Zone, 0, len(raw))
+	ids := make(map[string]bool, len(raw))
+	for _, zj := range raw {
+		z := zj.Zone
+		if z.ID == "" || z.FloorID == "" {
+			return nil, errors.New("dnas: zone id and floorId required")
+		}
+		if ids[z.ID] {
+			return nil, fmt.Errorf("dnas: duplicate zone id %s", z.ID)
+		}
+		ids[z.ID] = true
+		if len(z.Vertices) < 3 {
+			return nil, fmt.Errorf("dnas: zone %s must have at least three vertices", z.ID)
+		}
+		for _, v := range z.Vertices {
+			if v.Unit != "" && ParseUnit(v.Unit) == "" {
+				return nil, fmt.Errorf("dnas: zone %s: unknown vertex unit %q", z.ID, v.Unit)
+			}
+		}
+		if zj.DwellThreshold != "" {
+			d, err := time.ParseDuration(zj.DwellThreshold)
+			if err != nil {
+				return nil, fmt.Errorf("dnas: zone %s: invalid dwellThreshold: %w", z.ID, err)
+			}
+			z.DwellThreshold = d
+		}
+		zones = append(zones, z)
+	}
+	return zones, nil
+}
+
+// LoadZonesFile reads zone definitions from a JSON or YAML file.  See LoadZones.
+func LoadZonesFile(name string) ([]Zone, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return LoadZones(f)
+}
+
+// ZoneTracker evaluates device locations against zones and emits events as devices move between them.
+// It is safe for concurrent use.
+type ZoneTracker struct {
+	// Unit is the unit of the observed coordinates, which zone vertices given in another unit are converted to.
+	// If empty, UnitFeet is used, as DNA Spaces defaults to feet.
+	Unit Unit
+
+	mu       sync.Mutex
+	zones    []Zone
+	devices  map[string]*zoneDevice
+	occupied map[string]int
+}
+
+// zoneDevice holds the state of a single device for a ZoneTracker.
+type zoneDevice struct {
+	lastSeen time.Time
+	inside   map[string]*zonePresence
+}
+
+// zonePresence records when a device entered a zone and whether its dwell has been reported.
+type zonePresence struct {
+	entered       time.Time
+	dwellReported bool
+}
+
+// NewZoneTracker returns a ZoneTracker for the given zones.
+func NewZoneTracker(zones []Zone) *ZoneTracker {
+	return &ZoneTracker{
+		zones:    zones,
+		devices:  make(map[string]*zoneDevice),
+		occupied: make(map[string]int),
+	}
+}
+
+// Observe records the position of a device and returns any resulting events.
+// Observations older than the last one seen for the device are ignored.
+func (t *ZoneTracker) Observe(macAddress, floorID string, x, y float64, ts time.Time) []ZoneEvent {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	dev := t.devices[macAddress]
+	if dev == nil {
+		dev = &zoneDevice{inside: make(map[string]*zonePresence)}
+		t.devices[macAddress] = dev
+	} else if ts.Before(dev.lastSeen) {
+		return nil
+	}
+	dev.lastSeen = ts
+
+	unit := t.Unit
+	if unit == "" {
+		unit = UnitFeet
+	}
+	var events []ZoneEvent
+	for _, z := range t.zones {
+		in := z.FloorID == floorID && z.Contains(x, y, unit)
+		p, wasIn := dev.inside[z.ID]
+		switch {
+		case in && !wasIn:
+			dev.inside[z.ID] = &zonePresence{entered: ts}
+			t.occupied[z.ID]++
+			events = append(events, newZoneEvent(ZoneEntered, z, macAddress, ts, 0))
+		case in && wasIn:
+			dwell := ts.Sub(p.entered)
+			if z.DwellThreshold > 0 && !p.dwellReported && dwell > z.DwellThreshold {
+				p.dwellReported = true
+				events = append(events, newZoneEvent(DwellExceeded, z, macAddress, ts, dwell))
+			}
+		case !in && wasIn:
+			events = append(events, t.exit(dev, z, macAddress, ts))
+		}
+	}
+	return events
+}
+
+// ObserveLocationDevice records the position of a device returned by the Active Clients API.
+// If the device does not include a location time, the current time is used.
+func (t *ZoneTracker) ObserveLocationDevice(d LocationDevice) []ZoneEvent {
+	p, ok := d.Point()
+	if !ok {
+		return nil
+	}
+	ts := d.LocatedAt()
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	return t.Observe(d.MacAddress, d.FloorID, p.X, p.Y, ts)
+}
+
+// ObserveHistoryItems records the positions from the history records in time order and returns the resulting events.
+// Records with a timestamp or coordinates that can't be parsed are skipped, and the number skipped is returned.
+func (t *ZoneTracker) ObserveHistoryItems(items []HistoryItem) ([]ZoneEvent, int) {
+	type obs struct {
+		item HistoryItem
+		ts   time.Time
+		p    Point
+	}
+	observations := make([]obs, 0, len(items))
+	skipped := 0
+	for _, item := range items {
+		ts, err := item.Timestamp()
+		if err != nil {
+			skipped++
+			continue
+		}
+		p, err := item.Point()
+		if err != nil {
+			skipped++
+			continue
+		}
+		observations = append(observations, obs{item, ts, p})
+	}
+	sort.SliceStable(observations, func(i, j int) bool { return observations[i].ts.Before(observations[j].ts) })
+	var events []ZoneEvent
+	for _, o := range observations {
+		events = append(events, t.Observe(o.item.MacAddress, o.item.FloorID, o.p.X, o.p.Y, o.ts)...)
+	}
+	return events, skipped
+}
+
+// Expire exits every device that has not been seen since the given time from the zones it is in.
+// The exit events are timestamped with the time the device was last seen.
+func (t *ZoneTracker) Expire(before time.Time) []ZoneEvent {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var events []ZoneEvent
+	for mac, dev := range t.devices {
+		if !dev.lastSeen.Before(before) {
+			continue
+		}
+		for _, z := range t.zones {
+			if _, ok := dev.inside[z.ID]; ok {
+				events = append(events, t.exit(dev, z, mac, dev.lastSeen))
+			}
+		}
+		delete(t.devices, mac)
+	}
+	return events
+}
+
+// Occupancy returns the number of devices currently in each zone, keyed by zone ID.
+func (t *ZoneTracker) Occupancy() map[string]int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	counts := make(map[string]int, len(t.zones))
+	for _, z := range t.zones {
+		counts[z.ID] = t.occupied[z.ID]
+	}
+	return counts
+}
+
+// exit removes the device from the zone and returns the ZoneExited event.  The caller must hold t.mu.
+func (t *ZoneTracker) exit(dev *zoneDevice, z Zone, macAddress string, ts time.Time) ZoneEvent {
+	p := dev.inside[z.ID]
+	delete(dev.inside, z.ID)
+	t.occupied[z.ID]--
+	return newZoneEvent(ZoneExited, z, macAddress, ts, ts.Sub(p.entered))
+}
+
+// newZoneEvent returns an event of the given type for the device and zone.
+func newZoneEvent(typ ZoneEventType, z Zone, macAddress string, ts time.Time, dwell time.Duration) ZoneEvent {
+	return ZoneEvent{
+		Type:       typ,
+		ZoneID:     z.ID,
+		ZoneName:   z.Name,
+		FloorID:    z.FloorID,
+		MacAddress: macAddress,
+		Timestamp:  ts,
+		Dwell:      dwell,
+	}
+}
@@ -0,0 +1,137 @@
+package dnas
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testZonesYAML = `
+zones:
+  - id: 1
+    name: Meeting Room 1
+    floorId: f1
+    vertices: [{x: 10, y: 10}, {x: 40, y: 10}, {x: 40, y: 30}, {x: 10, y: 30}]
+    dwellThreshold: 45m
+  - id: "007"
+    floorId: f1
+    vertices:
+      - {x: 0, y: 0, unit: METERS}
+      - {x: 3, y: 0, unit: METERS}
+      - {x: 3, y: 3, unit: METERS}
+`
+
+func TestLoadZones(t *testing.T) {
+	jsonZones := `[{"id":"1","name":"Meeting Room 1","floorId":"f1","dwellThreshold":"45m",` +
+		`"vertices":[{"x":10,"y":10},{"x":40,"y":10},{"x":40,"y":30},{"x":10,"y":30}]},` +
+		`{"id":"007","floorId":"f1","vertices":[{"x":0,"y":0,"unit":"METERS"},{"x":3,"y":0,"unit":"METERS"},{"x":3,"y":3,"unit":"METERS"}]}]`
+	for name, doc := range map[string]string{"yaml": testZonesYAML, "json": jsonZones} {
+		zones, err := LoadZones(strings.NewReader(doc))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(zones) != 2 || zones[0].ID != "1" || zones[1].ID != "007" || zones[0].FloorID != "f1" {
+			t.Fatalf("%s: zones %+v", name, zones)
+		}
+		if zones[0].DwellThreshold != 45*time.Minute || zones[0].Name != "Meeting Room 1" || len(zones[0].Vertices) != 4 {
+			t.Errorf("%s: zone %+v", name, zones[0])
+		}
+		if zones[1].Vertices[2] != (MapItemCorner{X: 3, Y: 3, Unit: "METERS"}) {
+			t.Errorf("%s: vertex %+v", name, zones[1].Vertices[2])
+		}
+	}
+
+	bad := []string{
+		`[{"id":"a","vertices":[{"x":0,"y":0},{"x":1,"y":0},{"x":1,"y":1}]}]`,
+		`[{"id":"a","floorId":"f","vertices":[{"x":0,"y":0},{"x":1,"y":0}]}]`,
+		`[{"id":"a","floorId":"f","dwellThreshold":"soon","vertices":[{"x":0,"y":0},{"x":1,"y":0},{"x":1,"y":1}]}]`,
+		`[{"id":"a","floorId":"f","vertices":[{"x":0,"y":0,"unit":"cubits"},{"x":1,"y":0},{"x":1,"y":1}]}]`,
+		`[{"id":"a","floorId":"f","vertices":[{"x":0,"y":0},{"x":1,"y":0},{"x":1,"y":1}]},` +
+			`{"id":"a","floorId":"f","vertices":[{"x":0,"y":0},{"x":1,"y":0},{"x":1,"y":1}]}]`,
+		`zones: 1`,
+	}
+	for _, doc := range bad {
+		if _, err := LoadZones(strings.NewReader(doc)); err == nil {
+			t.Errorf("LoadZones(%s) expected error", doc)
+		}
+	}
+}
+
+// zoneEvents returns the type, zone and dwell of each event.
+func zoneEvents(events []ZoneEvent) string {
+	var s []string
+	for _, ev := range events {
+		s = append(s, fmt.Sprintf("%s:%s:%s", ev.Type, ev.ZoneID, ev.Dwell))
+	}
+	return strings.Join(s, " ")
+}
+
+func TestZoneTracker(t *testing.T) {
+	zones, err := LoadZones(strings.NewReader(testZonesYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := NewZoneTracker(zones)
+	steps := []struct {
+		floor string
+		x, y  float64
+		at    time.Duration
+		want  string
+	}{
+		// The metre zone covers 0 to about 9.8 feet.
+		{"f1", 5, 1, 0, "ZONE_ENTERED:007:0s"},
+		{"f1", 20, 20, time.Minute, "ZONE_ENTERED:1:0s ZONE_EXITED:007:1m0s"},
+		{"f1", 25, 20, 30 * time.Minute, ""},
+		{"f1", 25, 25, 50 * time.Minute, "DWELL_EXCEEDED:1:49m0s"},
+		{"f1", 25, 25, 55 * time.Minute, ""},
+		// Older observations are ignored.
+		{"f2", 25, 25, 10 * time.Minute, ""},
+		{"f2", 25, 25, time.Hour, "ZONE_EXITED:1:59m0s"},
+	}
+	for i, step := range steps {
+		got := zoneEvents(tr.Observe("a", step.floor, step.x, step.y, day0.Add(step.at)))
+		if got != step.want {
+			t.Errorf("step %d: events %q, want %q", i, got, step.want)
+		}
+	}
+
+	tr.Observe("b", "f1", 20, 20, day0)
+	if occ := tr.Occupancy(); occ["1"] != 1 || occ["007"] != 0 {
+		t.Errorf("occupancy %v, want 1 in zone 1", occ)
+	}
+	if got := zoneEvents(tr.Expire(day0.Add(time.Minute))); got != "ZONE_EXITED:1:0s" {
+		t.Errorf("expired %q, want b to exit", got)
+	}
+
+	// In metres, the metre zone covers 0 to 3 and the zone without units is taken to be in metres.
+	tr = NewZoneTracker(zones)
+	tr.Unit = UnitMeters
+	if got := zoneEvents(tr.Observe("a", "f1", 2, 1, day0)); got != "ZONE_ENTERED:007:0s" {
+		t.Errorf("events %q in metres, want the metre zone entered", got)
+	}
+	if got := zoneEvents(tr.Observe("a", "f1", 5, 1, day0.Add(time.Minute))); got != "ZONE_EXITED:007:1m0s" {
+		t.Errorf("events %q in metres, want the metre zone exited", got)
+	}
+}
+
+func TestZoneTrackerHistoryItems(t *testing.T) {
+	zones, err := LoadZones(strings.NewReader(testZonesYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms := func(d time.Duration) string { return fmt.Sprint(timeToMillis(day0.Add(d))) }
+	items := []HistoryItem{
+		{MacAddress: "a", FloorID: "f1", CoordinateX: "20", CoordinateY: "20", SourceTimestamp: ms(time.Minute)},
+		{MacAddress: "a", FloorID: "f1", CoordinateX: "20", CoordinateY: "20", SourceTimestamp: "yesterday"},
+		{MacAddress: "a", FloorID: "f1", CoordinateX: "x", CoordinateY: "20", SourceTimestamp: ms(0)},
+		{MacAddress: "a", FloorID: "f1", CoordinateX: "1", CoordinateY: "1", SourceTimestamp: ms(0)},
+	}
+	events, skipped := NewZoneTracker(zones).ObserveHistoryItems(items)
+	if skipped != 2 {
+		t.Errorf("skipped %d, want 2", skipped)
+	}
+	if got := zoneEvents(events); got != "ZONE_ENTERED:007:0s ZONE_ENTERED:1:0s ZONE_EXITED:007:1m0s" {
+		t.Errorf("events %q, want the valid records observed in time order", got)
+	}
+}